@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"http_exporter/slack"
+)
+
+// slackClient posts probe alerts to Slack. nil disables alerting.
+var slackClient *slack.SlackClient
+
+// alertColors are the colors of the targets alerted last, so that an alert
+// is posted only when the color of a target changes rather than on every
+// scrape. Healthy targets aren't kept, and as any /probe target can fail,
+// targets not probed for alertColorTTL are forgotten and at most
+// maxAlertColors are kept.
+type alertColors struct {
+	mu     sync.Mutex
+	colors map[string]alertColor
+}
+
+type alertColor struct {
+	color    string
+	lastSeen time.Time
+}
+
+const (
+	alertColorTTL  = time.Hour
+	maxAlertColors = 10000
+)
+
+func newAlertColors() *alertColors {
+	return &alertColors{colors: make(map[string]alertColor)}
+}
+
+// alertStates is shared by all probes.
+var alertStates = newAlertColors()
+
+// set records color for target, reporting whether it changed. A target
+// beyond maxAlertColors isn't recorded, and isn't alerted on either.
+func (a *alertColors) set(target, color string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	last, ok := a.colors[target]
+	if !ok || now.Sub(last.lastSeen) > alertColorTTL {
+		last.color = slack.ColorGood
+	}
+	if color == slack.ColorGood {
+		delete(a.colors, target)
+		return color != last.color
+	}
+	if !ok && len(a.colors) >= maxAlertColors {
+		a.prune(now)
+		if len(a.colors) >= maxAlertColors {
+			return false
+		}
+	}
+	a.colors[target] = alertColor{color: color, lastSeen: now}
+	return color != last.color
+}
+
+// prune forgets the targets not probed for alertColorTTL. a.mu must be held.
+func (a *alertColors) prune(now time.Time) {
+	for target, c := range a.colors {
+		if now.Sub(c.lastSeen) > alertColorTTL {
+			delete(a.colors, target)
+		}
+	}
+}
+
+// alertSlack alerts on a response of target. Failed probes are danger, and
+// the color of the others follows the TTFB band.
+func alertSlack(target string, statusCode int, ttfb time.Duration, failure string) {
+	if slackClient == nil {
+		return
+	}
+	color := slackClient.ColorForTTFB(ttfb)
+	text := fmt.Sprintf("status: %d, TTFB: %.1fms", statusCode, ns2ms(ttfb))
+	if failure != "" {
+		color = slack.ColorDanger
+		text = fmt.Sprintf("%s, failure: %s", text, failure)
+	}
+	postAlert(target, color, text)
+}
+
+// alertSlackError alerts on a probe of target failing without a response.
+func alertSlackError(target string, err error) {
+	if slackClient == nil {
+		return
+	}
+	postAlert(target, slack.ColorDanger, fmt.Sprintf("error: %s", failureReason(err)))
+}
+
+// postAlert posts text for target if its color changed, including back to
+// good. Silenced targets keep their last color, so that one still failing
+// when its silence ends is alerted.
+func postAlert(target, color, text string) {
+	if alertSilences.active(target) || !alertStates.set(target, color) {
+		return
+	}
+	go func() {
+		if err := slackClient.Post(target, "", text, color); err != nil {
+			log.Printf("Slack post error: %s", err)
+		}
+	}()
+}
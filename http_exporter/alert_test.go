@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"http_exporter/slack"
+)
+
+func TestAlertSlackTransitions(t *testing.T) {
+	colors := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p slack.Payload
+		json.Unmarshal([]byte(r.FormValue("payload")), &p)
+		colors <- p.Attachments[0].Color
+	}))
+	defer ts.Close()
+
+	sc, err := slack.NewSlack(ts.URL, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.TTFBWarn = time.Second
+	slackClient = sc
+	defer func() { slackClient, alertStates = nil, newAlertColors() }()
+
+	const target = "https://example.com"
+	alertSlack(target, 200, 10*time.Millisecond, "")
+	alertSlack(target, 200, 2*time.Second, "")
+	alertSlack(target, 200, 3*time.Second, "")
+	alertSlackError(target, syscall.ECONNREFUSED)
+	alertSlackError(target, errors.New("again"))
+	alertSlack(target, 404, 10*time.Millisecond, "status_code")
+	alertSlack(target, 200, 10*time.Millisecond, "")
+	alertSlack(target, 200, 10*time.Millisecond, "")
+	// A failed probe is danger however fast it is
+	alertSlack(target, 200, 10*time.Millisecond, "body_match")
+
+	// Posts are asynchronous, so they may arrive in any order
+	got := map[string]int{}
+	for i := 0; i < 4; i++ {
+		select {
+		case c := <-colors:
+			got[c]++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d posts, want 4", i)
+		}
+	}
+	select {
+	case c := <-colors:
+		t.Errorf("unexpected %s post", c)
+	case <-time.After(100 * time.Millisecond):
+	}
+	want := map[string]int{slack.ColorWarning: 1, slack.ColorDanger: 2, slack.ColorGood: 1}
+	for c, n := range want {
+		if got[c] != n {
+			t.Errorf("%s posts = %d, want %d", c, got[c], n)
+		}
+	}
+}
+
+func TestAlertColorsBounded(t *testing.T) {
+	a := newAlertColors()
+	for i := 0; i < maxAlertColors; i++ {
+		a.colors[fmt.Sprintf("https://%d.example.com", i)] = alertColor{slack.ColorDanger, time.Now()}
+	}
+	if a.set("https://example.com", slack.ColorDanger) {
+		t.Error("target beyond maxAlertColors alerted")
+	}
+
+	// Targets not probed for alertColorTTL are forgotten
+	expired := alertColor{slack.ColorDanger, time.Now().Add(-2 * alertColorTTL)}
+	a.colors["https://0.example.com"] = expired
+	if !a.set("https://example.com", slack.ColorDanger) {
+		t.Error("target not alerted after an expired one was forgotten")
+	}
+	if _, ok := a.colors["https://0.example.com"]; ok {
+		t.Error("expired target not forgotten")
+	}
+	a.colors["https://1.example.com"] = expired
+	if !a.set("https://1.example.com", slack.ColorDanger) {
+		t.Error("target still failing after alertColorTTL not alerted again")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errAuth = errors.New("auth")
+
+// resolveTokenFile resolves a token_file param within dir. Probes may only
+// read tokens from dir, as the param comes from whoever can scrape us.
+func resolveTokenFile(dir, name string) (string, error) {
+	if dir == "" {
+		return "", errors.New("token files are disabled, set -token-dir to enable them")
+	}
+	path, ok := confine(dir, name)
+	if !ok {
+		return "", errors.New("token file must be within -token-dir")
+	}
+	return path, nil
+}
+
+// confine joins name to dir, reporting whether the result is within dir.
+func confine(dir, name string) (string, bool) {
+	path := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path, true
+}
+
+// readToken reads a bearer token from path. The token itself never
+// appears in the returned error.
+func readToken(path string) (string, error) {
+	return readSecret("token", path)
+}
+
+// readSecret reads a kind of credential from path, trimming whitespace.
+func readSecret(kind, path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s file read error: %s", errAuth, kind, err)
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("%w: %s file %s is empty", errAuth, kind, path)
+	}
+	return secret, nil
+}
+
+// secret is a credential of a module, given inline, in an environment
+// variable read at startup, or in a file read on every probe so that
+// rotations apply.
+type secret struct {
+	kind  string // e.g. password, for errors
+	value string
+	file  string
+}
+
+// newSecret returns the secret given by at most one of value, file and
+// env, the name of an environment variable, or nil if none is.
+func newSecret(kind, value, file, env string) (*secret, error) {
+	n := 0
+	for _, s := range []string{value, file, env} {
+		if s != "" {
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		return nil, nil
+	case n > 1:
+		return nil, fmt.Errorf("only one of %s, %s_file and %s_env may be set", kind, kind, kind)
+	case env != "":
+		value = os.Getenv(env)
+		if value == "" {
+			return nil, fmt.Errorf("environment variable %s of %s is empty", env, kind)
+		}
+	}
+	return &secret{kind: kind, value: value, file: file}, nil
+}
+
+func (s *secret) get() (string, error) {
+	if s.file == "" {
+		return s.value, nil
+	}
+	return readSecret(s.kind, s.file)
+}
+
+// basicAuth is HTTP basic authentication with a password secret.
+type basicAuth struct {
+	username string
+	password *secret
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveTokenFile(t *testing.T) {
+	tests := []struct {
+		dir, name string
+		want      string
+		wantErr   bool
+	}{
+		{"/run/tokens", "api", "/run/tokens/api", false},
+		{"/run/tokens", "team/api", "/run/tokens/team/api", false},
+		{"/run/tokens", "../../etc/passwd", "", true},
+		{"/run/tokens", "/etc/passwd", "/run/tokens/etc/passwd", false},
+		{"", "api", "", true},
+	}
+	for _, tt := range tests {
+		got, err := resolveTokenFile(tt.dir, tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolveTokenFile(%q, %q) = %q, %v, want %q, wantErr %v", tt.dir, tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReadToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(path, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	token, err := readToken(path)
+	if err != nil || token != "s3cret" {
+		t.Errorf("readToken = %q, %v, want %q, nil", token, err, "s3cret")
+	}
+
+	if _, err := readToken(filepath.Join(dir, "missing")); !errors.Is(err, errAuth) {
+		t.Errorf("readToken of a missing file error = %v, want %v", err, errAuth)
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	t.Setenv("PROBE_PASSWORD", "from-env")
+
+	tests := []struct {
+		value, file, env string
+		want             string
+		wantErr          bool
+	}{
+		{"inline", "", "", "inline", false},
+		{"", "", "PROBE_PASSWORD", "from-env", false},
+		{"", "", "PROBE_UNSET", "", true},
+		{"inline", "", "PROBE_PASSWORD", "", true},
+	}
+	for _, tt := range tests {
+		s, err := newSecret("password", tt.value, tt.file, tt.env)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newSecret(%q, %q, %q) error = %v, wantErr %v", tt.value, tt.file, tt.env, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			if got, _ := s.get(); got != tt.want {
+				t.Errorf("newSecret(%q, %q, %q) = %q, want %q", tt.value, tt.file, tt.env, got, tt.want)
+			}
+		}
+	}
+	if s, err := newSecret("password", "", "", ""); s != nil || err != nil {
+		t.Errorf("newSecret of nothing = %v, %v, want nil, nil", s, err)
+	}
+}
+
+func TestProbeHandlerModuleAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !(ok && user == "probe" && password == "pw") && r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("tok\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PROBE_PASSWORD", "pw")
+
+	modules, err = loadModules(writeConfig(t, `
+modules:
+  basic:
+    basic_auth:
+      username: probe
+      password_env: PROBE_PASSWORD
+  bearer:
+    bearer_token_file: `+tokenFile+`
+  wrong:
+    bearer_token: wrong
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { modules = nil }()
+
+	for module, want := range map[string]string{"basic": "probe_success 1", "bearer": "probe_success 1", "wrong": "probe_http_status_code 401"} {
+		q := url.Values{"target": {ts.URL}, "module": {module}}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		if body := rec.Body.String(); !strings.Contains(body, want) {
+			t.Errorf("module %s: %q not found in:\n%s", module, want, body)
+		}
+	}
+
+	os.Remove(tokenFile)
+	q := url.Values{"target": {ts.URL}, "module": {"bearer"}}
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+	if want := `probe_failure_reason{reason="auth"} 1`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("%q not found after removing the token file:\n%s", want, rec.Body)
+	}
+
+	for _, bad := range []string{
+		"modules:\n  m:\n    basic_auth:\n      password: pw\n",
+		"modules:\n  m:\n    basic_auth:\n      username: u\n    bearer_token: t\n",
+		"modules:\n  m:\n    bearer_token: t\n    bearer_token_env: HOME\n",
+	} {
+		if _, err := loadModules(writeConfig(t, bad)); err == nil {
+			t.Errorf("loadModules(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// bodyResult is the outcome of draining a response body.
+type bodyResult struct {
+	bytes             int64         // bytes read off the wire
+	decompressedBytes int64         // bytes after decoding the Content-Encoding
+	truncated         bool          // whether reading stopped at the size limit
+	decoded           bool          // whether the Content-Encoding was decoded
+	decodeTime        time.Duration // time spent decoding, excluding waiting for the wire
+	sha256            string        // hex SHA-256 of the decoded body, empty if not hashed
+	content           []byte        // the decoded body, if kept
+	err               error
+}
+
+// countingReader counts the bytes read through it, and the time its reads
+// took.
+type countingReader struct {
+	r       io.Reader
+	n       int64
+	elapsed time.Duration
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.r.Read(p)
+	c.elapsed += time.Since(start)
+	c.n += int64(n)
+	return n, err
+}
+
+// drainBody reads body, decoding gzip or br if contentEncoding says so, up to
+// maxBytes of decoded content, hashing it on the way unless it is larger than
+// hashMaxBytes. 0 for hashMaxBytes disables hashing. Limiting the decoded size
+// rather than the wire size guards against decompression bombs. The decoded
+// body is kept in the result if keep is set.
+func drainBody(body io.Reader, contentEncoding string, maxBytes, hashMaxBytes int64, keep bool) bodyResult {
+	var h *cappedHash
+	var content bytes.Buffer
+	w := ioutil.Discard
+	switch {
+	case hashMaxBytes > 0 && keep:
+		h = &cappedHash{h: sha256.New(), remaining: hashMaxBytes}
+		w = io.MultiWriter(h, &content)
+	case hashMaxBytes > 0:
+		h = &cappedHash{h: sha256.New(), remaining: hashMaxBytes}
+		w = h
+	case keep:
+		w = &content
+	}
+
+	var r bodyResult
+	wire := &countingReader{r: body}
+	var decoded io.Reader = wire
+	switch contentEncoding {
+	case "gzip":
+		zr, err := gzip.NewReader(wire)
+		if err != nil {
+			r.bytes, r.err = wire.n, err
+			return r
+		}
+		defer zr.Close()
+		decoded = zr
+	case "br":
+		decoded = brotli.NewReader(wire)
+	}
+	r.decoded = decoded != io.Reader(wire)
+
+	// The decoder reads from the wire, so the time of its own reads less
+	// that of the wire's is the time spent decoding
+	timed := &countingReader{r: decoded}
+	r.decompressedBytes, r.err = io.Copy(w, io.LimitReader(timed, maxBytes+1))
+	if r.decoded {
+		r.decodeTime = timed.elapsed - wire.elapsed
+	}
+	if r.decompressedBytes > maxBytes {
+		r.decompressedBytes = maxBytes
+		r.truncated = true
+	}
+	if keep {
+		r.content = content.Bytes()[:r.decompressedBytes]
+	}
+	r.bytes = wire.n
+	if r.bytes > maxBytes {
+		r.bytes = maxBytes
+	}
+	if h != nil && !h.over && r.err == nil && !r.truncated {
+		r.sha256 = hex.EncodeToString(h.h.Sum(nil))
+	}
+	return r
+}
+
+// cappedHash hashes up to remaining bytes, and stops hashing once more are
+// written, discarding them.
+type cappedHash struct {
+	h         hash.Hash
+	remaining int64
+	over      bool // whether more than remaining bytes were written
+}
+
+func (c *cappedHash) Write(p []byte) (int, error) {
+	if c.over {
+		return len(p), nil
+	}
+	if int64(len(p)) > c.remaining {
+		c.over = true
+		return len(p), nil
+	}
+	c.remaining -= int64(len(p))
+	return c.h.Write(p)
+}
+
+// contentLengthMismatch reports whether the body size differs from the
+// declared Content-Length. ok is false if they can't be compared, e.g. for
+// chunked responses or bodies that weren't fully read.
+func contentLengthMismatch(contentLength int64, r bodyResult) (mismatch, ok bool) {
+	if contentLength < 0 || r.truncated {
+		return false, false
+	}
+	if r.err != nil && r.err != io.ErrUnexpectedEOF {
+		return false, false
+	}
+	return r.bytes != contentLength, true
+}
+
+// parseAcceptEncoding parses a comma separated list of the encodings
+// drainBody decodes, gzip and br, or identity not to compress.
+func parseAcceptEncoding(s string) (string, error) {
+	var encodings []string
+	for _, e := range strings.Split(s, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		switch e {
+		case "gzip", "br", "identity":
+			encodings = append(encodings, e)
+		default:
+			return "", fmt.Errorf("unsupported encoding %q, must be gzip, br or identity", e)
+		}
+	}
+	return strings.Join(encodings, ", "), nil
+}
+
+// parseSHA256 parses a hex SHA-256, as sha256sum prints it, into the form
+// of bodyResult.sha256.
+func parseSHA256(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("%q is not a hex SHA-256", s)
+	}
+	return s, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestDrainBodyGzipBomb(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(make([]byte, 1<<20))
+	zw.Close()
+	wireSize := int64(buf.Len())
+
+	r := drainBody(&buf, "gzip", 1000, 0, false)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if !r.truncated || r.decompressedBytes != 1000 {
+		t.Errorf("decompressedBytes = %d, truncated = %v, want 1000, true", r.decompressedBytes, r.truncated)
+	}
+	if r.bytes <= 0 || r.bytes > wireSize {
+		t.Errorf("bytes = %d, want between 1 and %d", r.bytes, wireSize)
+	}
+}
+
+func TestDrainBodyGzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("hello, world"))
+	zw.Close()
+	wireSize := int64(buf.Len())
+
+	r := drainBody(&buf, "gzip", 1000, 1000, true)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.bytes != wireSize || r.decompressedBytes != 12 || r.truncated {
+		t.Errorf("bytes, decompressedBytes, truncated = %d, %d, %v, want %d, 12, false",
+			r.bytes, r.decompressedBytes, r.truncated, wireSize)
+	}
+	if string(r.content) != "hello, world" {
+		t.Errorf("content = %q, want %q", r.content, "hello, world")
+	}
+	// sha256 of "hello, world"
+	if want := "09ca7e4eaa6e8ae9c7d261167129184883644d07dfba7cbfbc4c8a2e08360d5b"; r.sha256 != want {
+		t.Errorf("sha256 = %s, want %s", r.sha256, want)
+	}
+}
+
+func TestDrainBodyHashMaxBytes(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 1000)
+	sum := sha256.Sum256(data)
+	if r := drainBody(bytes.NewReader(data), "", 1<<20, 1000, false); r.sha256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("sha256 of a body of hashMaxBytes = %q, want %x", r.sha256, sum)
+	}
+	if r := drainBody(bytes.NewReader(append(data, 'x')), "", 1<<20, 1000, false); r.sha256 != "" || r.decompressedBytes != 1001 {
+		t.Errorf("body over hashMaxBytes: sha256 %q, %d bytes, want no hash and 1001 bytes", r.sha256, r.decompressedBytes)
+	}
+
+	// Hashing stops at the limit rather than going on to the end
+	h := &cappedHash{h: sha256.New(), remaining: 1000}
+	h.Write(data[:600])
+	h.Write(data[:600])
+	first := sha256.Sum256(data[:600])
+	if !h.over || !bytes.Equal(h.h.Sum(nil), first[:]) {
+		t.Errorf("cappedHash over %v, hashed more than the first write", h.over)
+	}
+}
+
+func TestProbeHandlerBodyTransfer(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "2000")
+		w.Write(make([]byte, 1000))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write(make([]byte, 1000))
+	}))
+	defer ts.Close()
+
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+url.Values{"target": {ts.URL}}.Encode(), nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"probe_http_content_length 2000",
+		"probe_http_uncompressed_body_bytes 2000",
+		"probe_http_download_throughput_bytes_per_second ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%q not found in:\n%s", want, body)
+		}
+	}
+	m := regexp.MustCompile(`(?m)^content_transfer_time(?:\{.*\})? (\S+)$`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("content_transfer_time not found in:\n%s", body)
+	}
+	if ms, _ := strconv.ParseFloat(m[1], 64); ms < 100 {
+		t.Errorf("content_transfer_time = %sms, want the 100ms the body took", m[1])
+	}
+}
+
+func TestProbeHandlerExpectedSHA256(t *testing.T) {
+	asset := []byte("body{color:red}")
+	sum := sha256.Sum256(asset)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tampered" {
+			w.Write([]byte("body{color:blue}"))
+			return
+		}
+		w.Write(asset)
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{"/", "probe_body_checksum_match 1"},
+		{"/tampered", `probe_failure_reason{reason="checksum"} 1`},
+	} {
+		// Upper case, as some tools print it
+		q := url.Values{"target": {ts.URL + tt.path}, "expected_sha256": {strings.ToUpper(hex.EncodeToString(sum[:]))}}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		if body := rec.Body.String(); !strings.Contains(body, tt.want) {
+			t.Errorf("%s: %q not found in:\n%s", tt.path, tt.want, body)
+		}
+	}
+
+	for _, bad := range []url.Values{
+		{"target": {ts.URL}, "expected_sha256": {"abc"}},
+		{"target": {ts.URL}, "expected_sha256": {hex.EncodeToString(sum[:])}, "head_only_timing": {"true"}},
+	} {
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+bad.Encode(), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", bad.Encode(), rec.Code)
+		}
+	}
+}
+
+func TestProbeHandlerAcceptEncoding(t *testing.T) {
+	page := bytes.Repeat([]byte("<p>hello, world</p>"), 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch accept := r.Header.Get("Accept-Encoding"); {
+		case strings.Contains(accept, "br"):
+			w.Header().Set("Content-Encoding", "br")
+			bw := brotli.NewWriter(w)
+			bw.Write(page)
+			bw.Close()
+		case strings.Contains(accept, "gzip"):
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			zw.Write(page)
+			zw.Close()
+		default:
+			w.Write(page)
+		}
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		acceptEncoding string
+		want           []string
+	}{
+		{"", []string{"probe_http_compressed 1", "probe_http_uncompressed_body_bytes 1900", "probe_http_decode_time "}},
+		{"br, gzip", []string{"probe_http_compressed 1", "probe_http_uncompressed_body_bytes 1900", "probe_http_compressed_body_bytes "}},
+		{"identity", []string{"probe_http_compressed 0", "probe_http_uncompressed_body_bytes 1900"}},
+	} {
+		q := url.Values{"target": {ts.URL}}
+		if tt.acceptEncoding != "" {
+			q.Set("accept_encoding", tt.acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		body := rec.Body.String()
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("accept_encoding=%q: %q not found in:\n%s", tt.acceptEncoding, want, body)
+			}
+		}
+		if tt.acceptEncoding == "identity" && strings.Contains(body, "probe_http_compressed_body_bytes") {
+			t.Errorf("accept_encoding=identity: probe_http_compressed_body_bytes sent:\n%s", body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+url.Values{"target": {ts.URL}, "accept_encoding": {"zstd"}}.Encode(), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("accept_encoding=zstd: status = %d, want 400", rec.Code)
+	}
+}
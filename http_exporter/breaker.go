@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// circuitBreaker short-circuits probes of targets that failed threshold
+// times in a row, until cooldown has passed. After the cooldown a single
+// trial probe is let through, and another failure reopens the circuit.
+// Targets are keyed by URL and module, see breakerKey. As any /probe
+// target can fail, at most maxBreakerTargets are tracked.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu      sync.Mutex
+	targets map[string]*breakerState
+}
+
+type breakerState struct {
+	failures    int
+	lastFailure time.Time
+	openUntil   time.Time
+}
+
+const maxBreakerTargets = 10000
+
+// breakerKey keys the circuit of target probed with module, as a module
+// may fail where another one succeeds.
+func breakerKey(target, module string) string {
+	return module + " " + target
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		targets:   make(map[string]*breakerState),
+	}
+}
+
+// allow reports whether target may be probed. A nil breaker allows all.
+// Once the cooldown of an open circuit has passed, the first caller gets
+// the trial probe, and the circuit stays open for others until the trial
+// is recorded, or for another cooldown if it never is.
+func (b *circuitBreaker) allow(target string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st, ok := b.targets[target]
+	if !ok || st.failures < b.threshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(st.openUntil) {
+		return false
+	}
+	st.openUntil = now.Add(b.cooldown)
+	return true
+}
+
+// record records the result of a probe of target.
+func (b *circuitBreaker) record(target string, success bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		delete(b.targets, target)
+		return
+	}
+	now := time.Now()
+	st, ok := b.targets[target]
+	if !ok {
+		if len(b.targets) >= maxBreakerTargets {
+			b.prune(now)
+			if len(b.targets) >= maxBreakerTargets {
+				// Not tracked, so the target is just never short-circuited
+				return
+			}
+		}
+		st = &breakerState{}
+		b.targets[target] = st
+	}
+	st.failures++
+	st.lastFailure = now
+	if st.failures >= b.threshold {
+		st.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// prune forgets the targets idle past the cooldown: those that neither
+// failed within it nor have an open circuit. b.mu must be held.
+func (b *circuitBreaker) prune(now time.Time) {
+	for target, st := range b.targets {
+		if now.Sub(st.lastFailure) > b.cooldown && !now.Before(st.openUntil) {
+			delete(b.targets, target)
+		}
+	}
+}
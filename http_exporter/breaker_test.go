@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(2, time.Hour)
+	target := breakerKey("https://example.com", "")
+
+	b.record(target, false)
+	if !b.allow(target) {
+		t.Fatal("circuit opened before reaching the threshold")
+	}
+	b.record(target, false)
+	if b.allow(target) {
+		t.Fatal("circuit still closed after reaching the threshold")
+	}
+	if !b.allow(breakerKey("https://example.org", "")) {
+		t.Error("circuit opened for another target")
+	}
+
+	if !b.allow(breakerKey("https://example.com", "other")) {
+		t.Error("circuit opened for another module")
+	}
+
+	b.targets[target].openUntil = time.Now()
+	if !b.allow(target) {
+		t.Fatal("circuit still open after the cooldown")
+	}
+	if b.allow(target) {
+		t.Fatal("second probe let through while the trial is in flight")
+	}
+	b.record(target, false)
+	if b.allow(target) {
+		t.Fatal("failed trial didn't reopen the circuit")
+	}
+
+	b.targets[target].openUntil = time.Now()
+	if !b.allow(target) {
+		t.Fatal("circuit still open after the second cooldown")
+	}
+	b.record(target, true)
+	if _, ok := b.targets[target]; ok {
+		t.Error("success didn't reset the target")
+	}
+}
+
+func TestCircuitBreakerBounded(t *testing.T) {
+	b := newCircuitBreaker(1, time.Hour)
+	for i := 0; i < maxBreakerTargets; i++ {
+		b.record(breakerKey(fmt.Sprintf("https://%d.example.com", i), ""), false)
+	}
+	target := breakerKey("https://example.com", "")
+	b.record(target, false)
+	if _, ok := b.targets[target]; ok {
+		t.Error("target tracked beyond maxBreakerTargets")
+	}
+
+	// A circuit idle past the cooldown makes room
+	idle := b.targets[breakerKey("https://0.example.com", "")]
+	idle.lastFailure = time.Now().Add(-2 * time.Hour)
+	idle.openUntil = idle.lastFailure.Add(time.Hour)
+	b.record(target, false)
+	if b.allow(target) {
+		t.Error("circuit not opened once an idle target was forgotten")
+	}
+	if len(b.targets) != maxBreakerTargets {
+		t.Errorf("%d targets tracked, want %d", len(b.targets), maxBreakerTargets)
+	}
+}
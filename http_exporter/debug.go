@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/httputil"
+)
+
+// sensitiveHeaders are redacted from debug dumps.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+func redactHeader(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+	for k, v := range h {
+		redacted[k] = v
+	}
+	for _, k := range sensitiveHeaders {
+		if _, ok := redacted[k]; ok {
+			redacted.Set(k, "REDACTED")
+		}
+	}
+	return redacted
+}
+
+// debugDumpRequest logs the request line and headers of req.
+func debugDumpRequest(req *http.Request) {
+	// Dumping must not fire the probe's trace hooks.
+	r := req.Clone(context.Background())
+	r.Header = redactHeader(req.Header)
+	dump, err := httputil.DumpRequestOut(r, false)
+	if err != nil {
+		log.Printf("debug: request dump error: %s", err)
+		return
+	}
+	log.Printf("debug: request to %s\n%s", req.URL, dump)
+}
+
+// debugDumpResponse logs the status line and headers of resp.
+func debugDumpResponse(resp *http.Response) {
+	r := *resp
+	r.Header = redactHeader(resp.Header)
+	dump, err := httputil.DumpResponse(&r, false)
+	if err != nil {
+		log.Printf("debug: response dump error: %s", err)
+		return
+	}
+	log.Printf("debug: response from %s\n%s", resp.Request.URL, dump)
+}
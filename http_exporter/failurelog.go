@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// failureLog keeps long outages from flooding the log: only the first of
+// consecutive failed visits of a target is logged, then a summary every
+// interval, and the recovery. As any /probe target can fail, streaks of
+// targets not probed for failureStreakTTL are forgotten, and at most
+// maxFailureStreaks are kept.
+type failureLog struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	targets map[string]*failureStreak
+}
+
+type failureStreak struct {
+	failures    int
+	since       time.Time
+	lastLogged  time.Time
+	lastFailure time.Time
+}
+
+const (
+	failureStreakTTL  = time.Hour
+	maxFailureStreaks = 10000
+)
+
+func newFailureLog(interval time.Duration) *failureLog {
+	return &failureLog{
+		interval: interval,
+		targets:  make(map[string]*failureStreak),
+	}
+}
+
+// failed logs the failed visit of target. A nil log logs every failure.
+func (l *failureLog) failed(target string, err error) {
+	if l == nil {
+		log.Printf("URL visit error: %s", err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	st, ok := l.targets[target]
+	if !ok || now.Sub(st.lastFailure) > failureStreakTTL {
+		if !ok && len(l.targets) >= maxFailureStreaks {
+			l.prune(now)
+		}
+		// Untracked beyond maxFailureStreaks, so every failure is logged
+		if ok || len(l.targets) < maxFailureStreaks {
+			l.targets[target] = &failureStreak{failures: 1, since: now, lastLogged: now, lastFailure: now}
+		}
+		log.Printf("URL visit error: %s", err)
+		return
+	}
+	st.failures++
+	st.lastFailure = now
+	if now.Sub(st.lastLogged) >= l.interval {
+		st.lastLogged = now
+		log.Printf("Probe of %s still failing, %d failures since %s, last: %s", target, st.failures, st.since.Format(time.RFC3339), err)
+	}
+}
+
+// prune forgets the streaks of targets not probed for failureStreakTTL.
+// l.mu must be held.
+func (l *failureLog) prune(now time.Time) {
+	for target, st := range l.targets {
+		if now.Sub(st.lastFailure) > failureStreakTTL {
+			delete(l.targets, target)
+		}
+	}
+}
+
+// succeeded ends the failure streak of target, if any.
+func (l *failureLog) succeeded(target string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if st, ok := l.targets[target]; ok && st.failures > 1 {
+		log.Printf("Probe of %s recovered after %d failures since %s", target, st.failures, st.since.Format(time.RFC3339))
+	}
+	delete(l.targets, target)
+}
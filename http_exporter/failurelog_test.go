@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailureLog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	l := newFailureLog(time.Hour)
+	const target = "https://example.com"
+	for i := 0; i < 3; i++ {
+		l.failed(target, errors.New("connection refused"))
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Errorf("%d lines logged for 3 failures within the interval, want 1:\n%s", n, buf.String())
+	}
+
+	l.targets[target].lastLogged = time.Now().Add(-time.Hour)
+	l.failed(target, errors.New("connection refused"))
+	if !strings.Contains(buf.String(), "still failing, 4 failures") {
+		t.Errorf("no summary after the interval:\n%s", buf.String())
+	}
+
+	l.succeeded(target)
+	if !strings.Contains(buf.String(), "recovered after 4 failures") {
+		t.Errorf("no recovery logged:\n%s", buf.String())
+	}
+	if _, ok := l.targets[target]; ok {
+		t.Error("success didn't reset the target")
+	}
+}
+
+func TestFailureLogBounded(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	l := newFailureLog(time.Hour)
+	for i := 0; i < maxFailureStreaks; i++ {
+		l.failed(fmt.Sprintf("https://%d.example.com", i), errors.New("connection refused"))
+	}
+	const target = "https://example.com"
+	l.failed(target, errors.New("connection refused"))
+	if _, ok := l.targets[target]; ok {
+		t.Error("target tracked beyond maxFailureStreaks")
+	}
+
+	// Streaks not failing for failureStreakTTL are forgotten
+	l.targets["https://0.example.com"].lastFailure = time.Now().Add(-2 * failureStreakTTL)
+	l.failed(target, errors.New("connection refused"))
+	if _, ok := l.targets["https://0.example.com"]; ok {
+		t.Error("stale streak not forgotten")
+	}
+	if _, ok := l.targets[target]; !ok {
+		t.Error("target not tracked once a stale streak was forgotten")
+	}
+}
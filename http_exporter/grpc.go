@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServingStatuses are the values of HealthCheckResponse.ServingStatus.
+var grpcServingStatuses = []string{"UNKNOWN", "SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}
+
+// grpcResult is the outcome of a gRPC health check.
+type grpcResult struct {
+	rpcTime       time.Duration
+	statusCode    int    // grpc-status of the RPC
+	servingStatus string // one of grpcServingStatuses if statusCode is 0
+}
+
+// errGRPCStatus is returned for RPCs failing with a grpc-status.
+var errGRPCStatus = errors.New("gRPC health check failed")
+
+// visitGRPC calls grpc.health.v1.Health/Check on the target, in plaintext
+// for http targets and over TLS for https ones.
+func (c *httpStatsCollector) visitGRPC() (grpcResult, error) {
+	var r grpcResult
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return r, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), map[string]string{"http": "80", "https": "443"}[u.Scheme])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout())
+	defer cancel()
+
+	dial := c.dialer()
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	// grpc-go reports connection errors as UNAVAILABLE, so the dial error
+	// is kept to be reported with its own failure reason.
+	var mu sync.Mutex
+	var dialErr error
+	creds := insecure.NewCredentials()
+	if u.Scheme == "https" {
+		creds = credentials.NewTLS(c.tlsClientConfig(u.Hostname()))
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, "tcp", addr)
+			mu.Lock()
+			dialErr = err
+			mu.Unlock()
+			return conn, err
+		}),
+	}
+	if c.host != "" {
+		opts = append(opts, grpc.WithAuthority(c.host))
+	}
+	// passthrough hands addr to the dialer as is, so that it resolves it
+	conn, err := grpc.NewClient("passthrough:///"+addr, opts...)
+	if err != nil {
+		return r, err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: c.grpcService})
+	r.rpcTime = time.Since(start)
+	if err != nil {
+		mu.Lock()
+		defer mu.Unlock()
+		st := status.Convert(err)
+		if st.Code() == codes.Unavailable && dialErr != nil {
+			return r, dialErr
+		}
+		if st.Code() == codes.DeadlineExceeded {
+			return r, context.DeadlineExceeded
+		}
+		r.statusCode = int(st.Code())
+		return r, fmt.Errorf("%w: grpc-status %d: %s", errGRPCStatus, r.statusCode, st.Message())
+	}
+
+	r.servingStatus = "UNKNOWN"
+	if s := int(resp.GetStatus()); s < len(grpcServingStatuses) {
+		r.servingStatus = grpcServingStatuses[s]
+	}
+	return r, nil
+}
+
+// collectGRPC collects the metrics of a grpc probe.
+func (c *httpStatsCollector) collectGRPC(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	r, err := c.visitGRPC()
+	sendGauge(ch, c.probeDuration, time.Since(start).Seconds())
+	if r.rpcTime > 0 {
+		sendGauge(ch, c.grpcRPCTime, ns2ms(r.rpcTime))
+	}
+	if err != nil && !errors.Is(err, errGRPCStatus) {
+		log.Printf("gRPC health check error: %s", err)
+		c.sendResult(ch, failureReason(err))
+		return
+	}
+	sendGauge(ch, c.grpcStatusCode, float64(r.statusCode))
+	if err != nil {
+		log.Printf("gRPC health check error: %s", err)
+		c.sendResult(ch, "grpc_status")
+		return
+	}
+
+	for _, s := range grpcServingStatuses {
+		sendGauge(ch, c.grpcHealthResponse, bool2float(s == r.servingStatus), s)
+	}
+	if r.servingStatus != "SERVING" {
+		c.sendResult(ch, "not_serving")
+		return
+	}
+	c.sendResult(ch, "")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// grpcHealthServer answers health checks of the service "" as SERVING,
+// "down" as NOT_SERVING, and others with grpc-status 5 (NOT_FOUND) in a
+// trailers-only response. It returns the URL of the server.
+func grpcHealthServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := health.NewServer()
+	hs.SetServingStatus("down", healthpb.HealthCheckResponse_NOT_SERVING)
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, hs)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	return "http://" + l.Addr().String()
+}
+
+func TestProbeHandlerGRPC(t *testing.T) {
+	target := grpcHealthServer(t)
+
+	tests := []struct {
+		service string
+		want    []string
+	}{
+		{"", []string{"probe_success 1", `probe_grpc_healthcheck_response{serving_status="SERVING"} 1`, "grpc_rpc_time "}},
+		{"down", []string{"probe_success 0", `reason="not_serving"`, `probe_grpc_healthcheck_response{serving_status="NOT_SERVING"} 1`}},
+		{"missing", []string{"probe_success 0", `reason="grpc_status"`, "probe_grpc_status_code 5"}},
+	}
+	for _, tt := range tests {
+		q := url.Values{"target": {target}, "grpc": {"true"}, "grpc_service": {tt.service}}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		for _, want := range tt.want {
+			if body := rec.Body.String(); !strings.Contains(body, want) {
+				t.Errorf("service %q: %s not found in:\n%s", tt.service, want, body)
+			}
+		}
+	}
+}
+
+func TestProbeHandlerGRPCConnectionRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	q := url.Values{"target": {"http://" + l.Addr().String()}, "grpc": {"true"}}
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, `probe_failure_reason{reason="connection_refused"} 1`) {
+		t.Errorf("connection_refused reason not found in:\n%s", body)
+	}
+	if strings.Contains(body, "probe_grpc_status_code") {
+		t.Errorf("unexpected probe_grpc_status_code in:\n%s", body)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Transport makes HTTP/3 requests over QUIC. quic-go doesn't report
+// to httptrace, so the dial does on its behalf: DNS, and the QUIC handshake
+// as the TLS handshake, which it carries. Closing it closes its connections.
+type http3Transport struct {
+	c  *httpStatsCollector
+	rt *http3.Transport
+
+	mu       sync.Mutex
+	dialed   map[string]bool // authorities with a connection
+	udpConns []*net.UDPConn
+}
+
+func (c *httpStatsCollector) newHTTP3Transport() *http3Transport {
+	t := &http3Transport{c: c, dialed: make(map[string]bool)}
+	t.rt = &http3.Transport{
+		TLSClientConfig:        c.tlsClientConfig(""),
+		MaxResponseHeaderBytes: c.maxResponseHeaderBytes,
+		Dial:                   t.dial,
+	}
+	return t
+}
+
+func (t *http3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	t.mu.Lock()
+	reused := t.dialed[authority(req)]
+	t.mu.Unlock()
+	if reused && trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{Reused: true})
+	}
+
+	resp, err := t.rt.RoundTrip(req)
+	if err == nil && trace != nil && trace.GotFirstResponseByte != nil {
+		// Not the first byte but the parsed headers, the closest quic-go tells
+		trace.GotFirstResponseByte()
+	}
+	return resp, err
+}
+
+func (t *http3Transport) Close() error {
+	err := t.rt.Close()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, conn := range t.udpConns {
+		conn.Close()
+	}
+	return err
+}
+
+// dial resolves addr with the resolver of the collector, and returns once
+// the QUIC handshake is complete so that it can be timed.
+func (t *http3Transport) dial(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+	c := t.c
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace == nil {
+		trace = &httptrace.ClientTrace{}
+	}
+
+	host, port, err := net.SplitHostPort(c.resolve.rewrite(addr))
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		if trace.DNSStart != nil {
+			trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+		}
+		resolver := c.netDialer().Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		network := "ip"
+		switch c.ipNetwork {
+		case "tcp4":
+			network = "ip4"
+		case "tcp6":
+			network = "ip6"
+		}
+		ips, err := resolver.LookupIP(ctx, network, host)
+		if trace.DNSDone != nil {
+			addrs := make([]net.IPAddr, len(ips))
+			for i, ip := range ips {
+				addrs[i] = net.IPAddr{IP: ip}
+			}
+			trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: err})
+		}
+		if err != nil {
+			return nil, err
+		}
+		ip = ips[0]
+		if c.preferredIP != "" {
+			if ip, err = preferIP(ips, c.preferredIP, c.ipFallback); err != nil {
+				return nil, fmt.Errorf("%s: %w", host, err)
+			}
+		}
+	}
+	raddr := &net.UDPAddr{IP: ip, Port: portNum}
+
+	// UDP has no handshake of its own, so connecting is just binding
+	if trace.ConnectStart != nil {
+		trace.ConnectStart("udp", raddr.String())
+	}
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: c.sourceIP})
+	if trace.ConnectDone != nil {
+		trace.ConnectDone("udp", raddr.String(), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.udpConns = append(t.udpConns, udpConn)
+	t.mu.Unlock()
+
+	hsCtx, cancel := c.tlsHandshakeContext(ctx)
+	defer cancel()
+	if trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	conn, err := quic.DialEarly(hsCtx, udpConn, raddr, tlsCfg, cfg)
+	if err == nil {
+		select {
+		case <-conn.HandshakeComplete():
+		case <-hsCtx.Done():
+			conn.CloseWithError(0, "")
+			err = hsCtx.Err()
+		}
+	}
+	if trace.TLSHandshakeDone != nil {
+		var cs tls.ConnectionState
+		if err == nil {
+			cs = conn.ConnectionState().TLS
+		}
+		trace.TLSHandshakeDone(cs, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.dialed[addr] = true
+	t.mu.Unlock()
+	if trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{})
+	}
+	return conn, nil
+}
+
+// authority returns the host:port of req as http3.Transport keys its
+// connections.
+func authority(req *http.Request) string {
+	host := req.URL.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443")
+	}
+	return host
+}
+
+// visitHTTP3 is visit over HTTP/3. The transport lives as long as the
+// response body, which closes it.
+func (c *httpStatsCollector) visitHTTP3() (stats, *http.Response, error) {
+	t := c.newHTTP3Transport()
+	s, resp, err := c.visitWith(t)
+	if err != nil {
+		t.Close()
+		return s, resp, err
+	}
+	resp.Body = &transportClosingBody{ReadCloser: resp.Body, transport: t}
+	return s, resp, nil
+}
+
+type transportClosingBody struct {
+	io.ReadCloser
+	transport io.Closer
+}
+
+func (b *transportClosingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.transport.Close()
+	return err
+}
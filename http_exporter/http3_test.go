@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestProbeHandlerHTTP3(t *testing.T) {
+	// Only for its certificate
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	srv := &http3.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.Proto)
+		}),
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: ts.TLS.Certificates}),
+	}
+	go srv.Serve(udpConn)
+	defer srv.Close()
+
+	target := "https://" + udpConn.LocalAddr().String()
+	mod := &module{HTTP3: true, TLSConfig: moduleTLSConfig{InsecureSkipVerify: true}}
+	defer func(m map[string]*module) { modules = m }(modules)
+	modules = map[string]*module{"h3": mod}
+
+	q := url.Values{"target": {target}, "module": {"h3"}}
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+	body := rec.Body.String()
+	for _, want := range []string{"probe_success 1", "probe_http_version 3", "quic_handshake_time{", "probe_connections_opened 1"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%q not found in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "tls_handshake_time{") {
+		t.Errorf("tls_handshake_time sent for HTTP/3:\n%s", body)
+	}
+
+	for _, bad := range []url.Values{
+		{"target": {"http://" + udpConn.LocalAddr().String()}, "http3": {"true"}},
+		{"target": {target}, "http3": {"true"}, "http_version": {"1.1"}},
+	} {
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+bad.Encode(), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", bad.Encode(), rec.Code)
+		}
+	}
+}
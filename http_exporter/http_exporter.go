@@ -1,22 +1,59 @@
 package main
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptrace"
-	"time"
+	"net/url"
+	"regexp"
 	"strconv"
-	"flag"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"http_exporter/slack"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// redirectHop is a request answered with a redirect that was followed.
+type redirectHop struct {
+	url      string
+	duration time.Duration // from the hop start until its redirect response
+}
+
 type stats struct {
-	tlsCert             *x509.Certificate
+	tlsCert      *x509.Certificate
+	tlsExpiry    time.Time     // earliest expiry of the certificates sent by the server
+	coldTTFB     time.Duration // TTFB of the warmup request, if any
+	redirectTime time.Duration // cumulative time spent on redirect hops
+	hops         []redirectHop // redirects followed, in order
+	tlsResumed   bool          // whether the TLS session was resumed
+	alpn         string        // protocol negotiated via ALPN, if any
+
+	tlsVersion     uint16
+	tlsCipherSuite uint16
+
+	sourceIP   net.IP // local address of the connection
+	ipProtocol int    // 4 or 6 by the remote address of the connection, 0 if unknown
+
+	requestID string // X-Request-ID sent with the request, if any
+
+	connections int // TCP connections opened, including for redirects
+
+	size       int64  // response size, if measured by sizeFromHead
+	sizeMethod string // method the size was measured with
 
 	Start                time.Time
 	DNSStart             time.Time
@@ -30,45 +67,333 @@ type stats struct {
 	Finish               time.Time
 }
 
+// span is the time between two events of a visit, or 0 unless both were
+// recorded, as for the phases a failed visit didn't get to.
+func span(from, to time.Time) time.Duration {
+	if from.IsZero() || to.IsZero() {
+		return 0
+	}
+	return to.Sub(from)
+}
+
 func (s *stats) dnsLookup() time.Duration {
-	return s.DNSDone.Sub(s.DNSStart)
+	return span(s.DNSStart, s.DNSDone)
 }
 
 func (s *stats) tcpConnection() time.Duration {
-	return s.ConnectDone.Sub(s.ConnectStart)
+	return span(s.ConnectStart, s.ConnectDone)
 }
 
 func (s *stats) tlsHandshake() time.Duration {
-	return s.TLSHandshakeDone.Sub(s.TLSHandshakeStart)
+	return span(s.TLSHandshakeStart, s.TLSHandshakeDone)
+}
+
+func (s *stats) preDNS() time.Duration {
+	// DNS is skipped for IPs and reused connections
+	if s.DNSStart.IsZero() {
+		return 0
+	}
+	return s.DNSStart.Sub(s.Start)
+}
+
+func (s *stats) preTLS() time.Duration {
+	// TLS never starts for plain HTTP
+	if s.TLSHandshakeStart.IsZero() || s.TLSHandshakeStart.Before(s.ConnectDone) {
+		return 0
+	}
+	return s.TLSHandshakeStart.Sub(s.ConnectDone)
+}
+
+func (s *stats) connectReady() time.Duration {
+	return span(s.Start, s.GotConn)
 }
 
 func (s *stats) serverProcessing() time.Duration {
-	return s.GotFirstResponseByte.Sub(s.GotConn)
+	return span(s.GotConn, s.GotFirstResponseByte)
 }
 
 func (s *stats) contentTransfer() time.Duration {
-	return s.Finish.Sub(s.GotFirstResponseByte)
+	return span(s.GotFirstResponseByte, s.Finish)
 }
 
 func (s *stats) ttfb() time.Duration {
-	return s.GotFirstResponseByte.Sub(s.Start)
+	return span(s.Start, s.GotFirstResponseByte)
+}
+
+func (s *stats) total() time.Duration {
+	return span(s.Start, s.Finish)
+}
+
+// accountingGap is the part of the total time not covered by any phase,
+// e.g. waiting for a pooled connection or redirects. It is negative when
+// phases overlap.
+func (s *stats) accountingGap() time.Duration {
+	phases := s.dnsLookup() + s.tcpConnection() + s.tlsHandshake() + s.serverProcessing() + s.contentTransfer()
+	return s.total() - phases
 }
 
 type httpStatsCollector struct {
-	url string
+	url     string
 	timeout int
 
-	dnsLookup        *prometheus.Desc
-	tcpConnection    *prometheus.Desc
-	tlsHandshake     *prometheus.Desc
-	serverProcessing *prometheus.Desc
-	contentTransfer  *prometheus.Desc
-	ttfb             *prometheus.Desc
+	phaseLabels []string // variable labels of the phase metrics
+	constLabels prometheus.Labels
+	warmup      bool
+	host        string // overrides the Host header if set
+	noTLS       bool   // rejects redirects to https
+	debug       bool   // dumps request and response headers to the log
+	tlsOnly     bool   // only performs the TLS handshake, without an HTTP request
+	tcp         bool   // only connects, for tcp://host:port targets
+	tcpTLS      bool   // performs the TLS handshake after connecting to a tcp target
+	wsEcho      bool   // upgrades to a WebSocket and times an echoed message instead
+	grpc        bool   // makes a gRPC health check instead of an HTTP request
+	grpcService string // service whose health the gRPC health check asks for
+
+	tokenFile     string        // file to read a bearer token from if set
+	oauth2        *oauth2Source // gets a bearer token of the module, unless tokenFile is set
+	bearerToken   *secret       // bearer token of the module, unless tokenFile is set
+	basicAuth     *basicAuth    // sent if set and there is no bearer token
+	bodyMatchFile string        // file to read a regex the body must match from if set
+
+	jsonAssertions  []*jsonAssertion // all must hold for a JSON body
+	bodyMatches     []*regexp.Regexp // all must match the body
+	bodyForbidden   []*regexp.Regexp // none may match the body, e.g. error pages served with 200
+	headerMatches   []*headerMatch   // all must match a response header
+	headerForbidden []*headerMatch   // none may match a response header
+	requestID       bool             // sends a random X-Request-ID with each request
+
+	method  string
+	headers http.Header // sent with each request, overriding the defaults
+	body    []byte      // request body, not sent with HEAD
+	http10  bool        // requests are made with HTTP/1.0, without keep-alive
+	http11  bool        // HTTP/2 isn't negotiated even if the server supports it
+	http3   bool        // requests are made with HTTP/3 over QUIC
+
+	clientCert *clientCert    // sent to servers asking for one if set
+	rootCAs    *x509.CertPool // verify server certificates instead of the system roots if set
+
+	validStatusCodes []int // other statuses fail the probe if set, see statusCodeValid
+
+	expectLocation *regexp.Regexp // redirects aren't followed, but checked against this if set
+	maxRedirects   int            // redirects followed at most, 0 not to follow any
+	sameHostOnly   bool           // fails redirects to other hosts than the target's
+	headForSize    bool           // probes with HEAD, getting the size from Content-Length
+	headOnlyTiming bool           // closes the body unread once the headers are in
+	retries        int            // the measured request is retried up to this many times
+	retryOn        []int          // statuses retried
+
+	ifNoneMatch     string // sent as If-None-Match if set
+	byteRange       string // sent as Range: bytes=<byteRange> if set
+	ifModifiedSince string // sent as If-Modified-Since if set
+
+	dnsTimeout     time.Duration // bounds name resolution alone if set
+	connectTimeout time.Duration // bounds name resolution and the TCP handshake if set
+	tlsTimeout     time.Duration // bounds the TLS handshake if set
+	totalTimeout   time.Duration // overrides timeout if set
+	dnsServer      *dnsServer    // resolves the target instead of the system resolver if set
+	socks5         *url.URL      // SOCKS5 proxy to dial through if set
+	sourceIP       net.IP        // local address to bind to if set
+	resolve        *resolveOverride
+	ipNetwork      string // tcp4 or tcp6 to only look up A or AAAA records if set
+	preferredIP    string // ip4 or ip6 to connect to addresses of that protocol first if set
+	ipFallback     bool   // connects to the other protocol if preferredIP has no address
+	nagle          bool   // enables Nagle's algorithm by clearing TCP_NODELAY
+
+	proxyProtocol *proxyProtocol // PROXY protocol header sent on connections if set
+
+	maxBodyBytes     int64  // the body is read up to this size
+	minBodyBytes     int64  // smaller bodies fail the probe
+	expectBody       bool   // a 200 declaring an empty body is not sane
+	bodyHashMaxBytes int64  // bodies larger than this are not hashed
+	expectedSHA256   string // the body must hash to this if set, e.g. for CDN assets
+	acceptEncoding   string // sent as Accept-Encoding, see parseAcceptEncoding
+
+	tcpSlowThreshold time.Duration // TCP handshakes slower than this are flagged
+	phaseSLOs        phaseSLOFlag  // budget of each phase, if any
+
+	maxResponseHeaderBytes int64 // 0 uses net/http's default
+
+	tlsSessionCache    tls.ClientSessionCache // enables TLS session resumption if set
+	insecureSkipVerify bool                   // skips verification of the server certificate
+	serverName         string                 // overrides the SNI and verified name if set
+
+	transport *http.Transport // reused across probes if set, instead of a fresh one
+
+	module     string          // name of the module applied, if any
+	breaker    *circuitBreaker // skips probes of failing targets if set
+	failureLog *failureLog     // logs failed visits sparingly if set
+	statsd     *statsdClient   // also gets the results if set
+
+	samples           int       // the probe is repeated this many times if above 1
+	samplePercentiles []float64 // percentiles reported over samples
+	sampleBuckets     []float64 // samples are reported as histograms with these buckets(ms) if set
+
+	budget   *requestBudget // caps HTTP requests per scrape if set, shared by the paths of a scrape
+	requests int            // HTTP requests made so far in this scrape by this collector
+	attempts int            // attempts of the measured requests, counting retries and samples
+
+	collectorDescs
+}
+
+// collectorDescs are the metric descriptions of a collector.
+type collectorDescs struct {
+	probeSuccess       *prometheus.Desc
+	probeDuration      *prometheus.Desc
+	probeTimestamp     *prometheus.Desc
+	dnsLookup          *prometheus.Desc
+	tcpConnection      *prometheus.Desc
+	tlsHandshake       *prometheus.Desc
+	quicHandshake      *prometheus.Desc
+	preDNS             *prometheus.Desc
+	wsRoundtrip        *prometheus.Desc
+	grpcRPCTime        *prometheus.Desc
+	grpcStatusCode     *prometheus.Desc
+	grpcHealthResponse *prometheus.Desc
+	preTLS             *prometheus.Desc
+	connectReady       *prometheus.Desc
+	serverProcessing   *prometheus.Desc
+	contentTransfer    *prometheus.Desc
+	ttfb               *prometheus.Desc
+	coldTTFB           *prometheus.Desc
+	altSvcH3           *prometheus.Desc
+	hstsEnabled        *prometheus.Desc
+	hstsMaxAge         *prometheus.Desc
+	redirectTime       *prometheus.Desc
+	redirects          *prometheus.Desc
+	redirectHopTime    *prometheus.Desc
+	failureReason      *prometheus.Desc
+	bodySHA256         *prometheus.Desc
+	checksumMatch      *prometheus.Desc
+	requestsTotal      *prometheus.Desc
+	attemptsTotal      *prometheus.Desc
+	connectSuccess     *prometheus.Desc
+	tcpHandshakeSlow   *prometheus.Desc
+	phaseOK            *prometheus.Desc
+	accountingGap      *prometheus.Desc
+	tlsResumed         *prometheus.Desc
+	alpnInfo           *prometheus.Desc
+	tlsVersionInfo     *prometheus.Desc
+	tlsCertInfo        *prometheus.Desc
+	tlsEarliestExpiry  *prometheus.Desc
+	tlsExpiryDays      *prometheus.Desc
+	tlsCipherInfo      *prometheus.Desc
+
+	certLifetimeFraction *prometheus.Desc
+	sourceIPInfo         *prometheus.Desc
+	httpVersionInfo      *prometheus.Desc
+	httpVersion          *prometheus.Desc
+	ipProtocol           *prometheus.Desc
+	requestIDInfo        *prometheus.Desc
+
+	contentLengthMismatch *prometheus.Desc
+	setCookieCount        *prometheus.Desc
+	clockSkew             *prometheus.Desc
+	responseSane          *prometheus.Desc
+	connectionsOpened     *prometheus.Desc
+	dnsCacheHit           *prometheus.Desc
+	alertsSilenced        *prometheus.Desc
+	retryAfter            *prometheus.Desc
+	httpStatusCode        *prometheus.Desc
+	responseSize          *prometheus.Desc
+	redirectCorrect       *prometheus.Desc
+	offHostRedirect       *prometheus.Desc
+	notModified           *prometheus.Desc
+	rangeSize             *prometheus.Desc
+	contentLength         *prometheus.Desc
+	uncompressedBodyBytes *prometheus.Desc
+	downloadThroughput    *prometheus.Desc
+	compressed            *prometheus.Desc
+	compressedBodyBytes   *prometheus.Desc
+	decodeTime            *prometheus.Desc
+	contentMatch          *prometheus.Desc
+	jsonAssertion         *prometheus.Desc
+	jsonAssertionResult   *prometheus.Desc
+	jsonValue             *prometheus.Desc
 }
 
 func (c *httpStatsCollector) visit() (stats, *http.Response, error) {
-	var s stats
-	trace := &httptrace.ClientTrace{
+	if c.http3 {
+		return c.visitHTTP3()
+	}
+	transport := c.transport
+	if transport == nil {
+		transport = c.newTransport()
+	}
+	return c.visitWith(transport)
+}
+
+func (c *httpStatsCollector) visitWith(transport http.RoundTripper) (stats, *http.Response, error) {
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   c.requestTimeout(),
+	}
+
+	var coldTTFB time.Duration
+	if c.warmup {
+		// The throwaway request establishes the connection so that the
+		// measured request below is served from a warm pool.
+		cold, resp, err := c.do(client, c.method)
+		if err != nil {
+			return cold, resp, err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		coldTTFB = cold.ttfb()
+	}
+
+	s, resp, err := c.do(client, c.method)
+	attempts := 1
+	for err == nil && attempts <= c.retries && c.shouldRetry(resp.StatusCode) {
+		log.Printf("Probe of %s got %d, retrying", c.url, resp.StatusCode)
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		s, resp, err = c.do(client, c.method)
+		attempts++
+	}
+	c.attempts += attempts
+	if err != nil {
+		return s, resp, err
+	}
+	s.coldTTFB = coldTTFB
+	if c.headOnlyTiming {
+		// Closing early saves the bandwidth of the body, at the price of
+		// the connection, which can't be reused with the body unread
+		resp.Body.Close()
+		s.Finish = s.GotFirstResponseByte
+	}
+	if c.headForSize {
+		c.sizeFromHead(client, &s, resp)
+	}
+	return s, resp, nil
+}
+
+// sizeFromHead takes the response size from the Content-Length of a HEAD
+// response, falling back to a GET if the server didn't send one.
+func (c *httpStatsCollector) sizeFromHead(client *http.Client, s *stats, head *http.Response) {
+	if head.ContentLength >= 0 {
+		s.size = head.ContentLength
+		s.sizeMethod = "HEAD"
+		return
+	}
+
+	_, resp, err := c.do(client, "GET")
+	if err != nil {
+		log.Printf("Size fallback GET error: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+	body := drainBody(resp.Body, resp.Header.Get("Content-Encoding"), c.maxBodyBytes, 0, false)
+	if body.err != nil {
+		log.Printf("Size fallback GET body read error: %s", body.err)
+		return
+	}
+	s.size = body.bytes
+	s.sizeMethod = "GET"
+}
+
+// newClientTrace returns a trace recording the timestamps of a request in s.
+func newClientTrace(s *stats) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
 		DNSStart: func(_ httptrace.DNSStartInfo) {
 			s.DNSStart = time.Now()
 		},
@@ -78,8 +403,12 @@ func (c *httpStatsCollector) visit() (stats, *http.Response, error) {
 		ConnectStart: func(_, _ string) {
 			s.ConnectStart = time.Now()
 		},
-		ConnectDone: func(_, addr string, _ error) {
+		ConnectDone: func(_, addr string, err error) {
 			s.ConnectDone = time.Now()
+			if err == nil {
+				s.connections++
+				s.ipProtocol = ipProtocol(addr)
+			}
 		},
 		TLSHandshakeStart: func() {
 			s.TLSHandshakeStart = time.Now()
@@ -88,231 +417,2082 @@ func (c *httpStatsCollector) visit() (stats, *http.Response, error) {
 			s.TLSHandshakeDone = time.Now()
 			if err == nil {
 				s.tlsCert = cs.PeerCertificates[0] // End Entity証明書のみ対応
+				s.tlsExpiry = earliestExpiry(cs.PeerCertificates)
+				s.tlsResumed = cs.DidResume
+				s.alpn = cs.NegotiatedProtocol
+				s.tlsVersion = cs.Version
+				s.tlsCipherSuite = cs.CipherSuite
 			}
 		},
-		GotConn: func(_ httptrace.GotConnInfo) {
+		GotConn: func(gci httptrace.GotConnInfo) {
 			s.GotConn = time.Now()
+			if gci.Conn == nil {
+				// HTTP/3, see http3Transport
+				return
+			}
+			if addr, ok := gci.Conn.LocalAddr().(*net.TCPAddr); ok {
+				s.sourceIP = addr.IP
+			}
+			// Also for reused connections, which aren't connected again
+			if p := ipProtocol(gci.Conn.RemoteAddr().String()); p != 0 {
+				s.ipProtocol = p
+			}
 		},
 		GotFirstResponseByte: func() {
 			s.GotFirstResponseByte = time.Now()
 		},
 	}
+}
+
+func (c *httpStatsCollector) do(client *http.Client, method string) (stats, *http.Response, error) {
+	var s stats
+	trace := newClientTrace(&s)
 
-	req, err := http.NewRequest("GET", c.url, nil)
+	var body io.Reader
+	if len(c.body) > 0 && method != "HEAD" {
+		body = bytes.NewReader(c.body)
+	}
+	req, err := http.NewRequest(method, c.url, body)
 	if err != nil {
 		log.Fatalf("Request generation error: %s", err)
 	}
+	if c.host != "" {
+		req.Host = c.host
+	}
+	if c.http10 {
+		// Only informative, see http10Conn
+		req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.0", 1, 0
+		req.Close = true
+	}
+	if c.tokenFile != "" {
+		// Read on every probe, as the token may be rotated by a sidecar
+		token, err := readToken(c.tokenFile)
+		if err != nil {
+			return s, nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.oauth2 != nil {
+		token, err := c.oauth2.accessToken(req.Context())
+		if err != nil {
+			return s, nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.bearerToken != nil {
+		token, err := c.bearerToken.get()
+		if err != nil {
+			return s, nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.basicAuth != nil {
+		password, err := c.basicAuth.password.get()
+		if err != nil {
+			return s, nil, err
+		}
+		req.SetBasicAuth(c.basicAuth.username, password)
+	}
+	if c.requestID {
+		s.requestID = newRequestID()
+		req.Header.Set("X-Request-ID", s.requestID)
+	}
+	if c.byteRange != "" {
+		req.Header.Set("Range", "bytes="+c.byteRange)
+	} else if method != "HEAD" {
+		// Asked for explicitly, the transport leaves the body compressed so
+		// that drainBody sees the wire bytes and bounds the decoded size.
+		// Not with a Range, which would cut the compressed stream.
+		req.Header.Set("Accept-Encoding", c.acceptEncoding)
+	}
+	if c.ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", c.ifNoneMatch)
+	}
+	if c.ifModifiedSince != "" {
+		req.Header.Set("If-Modified-Since", c.ifModifiedSince)
+	}
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+	if host := c.headers.Get("Host"); host != "" {
+		req.Host = host
+	}
 	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-		},
-		Timeout: time.Duration(c.timeout) * time.Second,
+	// Each hop lasts from the previous hop (or the start) until its
+	// redirect response arrives at CheckRedirect.
+	var hopStart time.Time
+	redirectClient := *client
+	redirectClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if hopStart.IsZero() {
+			hopStart = s.Start
+		}
+		now := time.Now()
+		s.redirectTime += now.Sub(hopStart)
+		hop := redirectHop{url: via[len(via)-1].URL.String(), duration: now.Sub(hopStart)}
+		hopStart = now
+
+		if c.expectLocation != nil || c.maxRedirects == 0 {
+			// The redirect itself is the response
+			return http.ErrUseLastResponse
+		}
+		if len(via) > c.maxRedirects {
+			return errTooManyRedirects
+		}
+		if c.debug {
+			debugDumpResponse(req.Response)
+			debugDumpRequest(req)
+		}
+		if c.noTLS && req.URL.Scheme == "https" {
+			return errTLSDisabled
+		}
+		if c.sameHostOnly && !strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) {
+			return &offHostError{host: req.URL.Hostname()}
+		}
+		if err := c.takeRequest(); err != nil {
+			return err
+		}
+		s.hops = append(s.hops, hop)
+		return nil
 	}
 
+	if err := c.takeRequest(); err != nil {
+		return s, nil, err
+	}
+	if c.debug {
+		debugDumpRequest(req)
+	}
 	s.Start = time.Now()
-	resp, err := client.Do(req)
+	resp, err := redirectClient.Do(req)
 	s.Finish = time.Now()
 	if err != nil {
 		return s, resp, err
 	}
+	if c.debug {
+		debugDumpResponse(resp)
+	}
 
 	return s, resp, nil
 }
 
-func newHTTPStatsCollector(url string, timeout int) *httpStatsCollector {
+var (
+	errRequestBudget    = errors.New("request budget exhausted")
+	errTLSDisabled      = errors.New("redirect to https rejected because TLS is disabled")
+	errTooManyRedirects = errors.New("too many redirects")
+)
+
+// offHostError rejects a redirect to another host than the target's.
+type offHostError struct {
+	host string
+}
+
+func (e *offHostError) Error() string {
+	return "redirect to another host " + e.host + " rejected"
+}
+
+// takeRequest accounts for one HTTP request of the probe, failing once the
+// per-scrape request budget is used up.
+func (c *httpStatsCollector) takeRequest() error {
+	if c.budget != nil && !c.budget.take() {
+		return errRequestBudget
+	}
+	c.requests++
+	return nil
+}
+
+// requestBudget caps the HTTP requests of a scrape. The collectors of the
+// paths of a scrape share one, taking from it concurrently.
+type requestBudget struct {
+	max   int64
+	taken int64 // accessed atomically
+}
+
+func newRequestBudget(max int) *requestBudget {
+	return &requestBudget{max: int64(max)}
+}
+
+// take takes a request from b, reporting false if there is none left.
+func (b *requestBudget) take() bool {
+	for {
+		taken := atomic.LoadInt64(&b.taken)
+		if taken >= b.max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.taken, taken, taken+1) {
+			return true
+		}
+	}
+}
+
+const (
+	defaultMaxRedirects = 10
+	// maxRedirectsLimit bounds max_redirects, and so the hop label values.
+	maxRedirectsLimit = 20
+)
+
+// defaultPhaseLabels are the labels of the phase metrics by default.
+var defaultPhaseLabels = []string{"status_code"}
+
+// newHTTPStatsCollector returns a collector probing url. phaseLabels are the
+// variable labels of the phase metrics, any of "status_code" (2xx), "code"
+// (200) and "result".
+func newHTTPStatsCollector(url string, timeout int, phaseLabels []string, constLabels prometheus.Labels) *httpStatsCollector {
 	return &httpStatsCollector{
-		url: url,
-		timeout: timeout,
+		url:            url,
+		timeout:        timeout,
+		phaseLabels:    phaseLabels,
+		constLabels:    constLabels,
+		method:         "GET",
+		maxBodyBytes:   10 << 20,
+		maxRedirects:   defaultMaxRedirects,
+		ipFallback:     true,
+		acceptEncoding: "gzip",
+		tcp:            strings.HasPrefix(url, "tcp://"),
+
+		collectorDescs: newCollectorDescs(phaseLabels, constLabels),
+	}
+}
+
+// forTarget returns a copy of c probing url, labeled with constLabels.
+func (c *httpStatsCollector) forTarget(url string, constLabels prometheus.Labels) *httpStatsCollector {
+	clone := *c
+	clone.url = url
+	clone.constLabels = constLabels
+	clone.collectorDescs = newCollectorDescs(c.phaseLabels, constLabels)
+	return &clone
+}
 
+func newCollectorDescs(phaseLabels []string, constLabels prometheus.Labels) collectorDescs {
+	return collectorDescs{
+		probeSuccess: prometheus.NewDesc(
+			"probe_success",
+			"Whether the probe succeeded",
+			nil,
+			constLabels,
+		),
+		probeDuration: prometheus.NewDesc(
+			"probe_duration_seconds",
+			"How long the probe took to complete in seconds",
+			nil,
+			constLabels,
+		),
+		probeTimestamp: prometheus.NewDesc(
+			"probe_timestamp_seconds",
+			"Unix time when the probe request started",
+			nil,
+			constLabels,
+		),
 		dnsLookup: prometheus.NewDesc(
 			"dns_lookup_time",
 			"A gauge of the DNS lookup durations(ms)",
-			[]string{"status_code"},
-			nil,
+			phaseLabels,
+			constLabels,
 		),
 		tcpConnection: prometheus.NewDesc(
 			"tcp_handshake_time",
 			"A gauge of the TCP handshake duration(ms)",
-			[]string{"status_code"},
-			nil,
+			phaseLabels,
+			constLabels,
+		),
+		tcpHandshakeSlow: prometheus.NewDesc(
+			"tcp_handshake_slow",
+			"Whether the TCP handshake exceeded the slow threshold",
+			phaseLabels,
+			constLabels,
+		),
+		phaseOK: prometheus.NewDesc(
+			"probe_phase_ok",
+			"Whether the phase took less than its budget set by -phase-slo",
+			[]string{"phase"},
+			constLabels,
 		),
 		tlsHandshake: prometheus.NewDesc(
 			"tls_handshake_time",
-			"A gauge of the TLS handshake duration(ms)",
-			[]string{"status_code"},
+			"A gauge of the TLS handshake duration(ms), by whether the session was resumed",
+			append(phaseLabels[:len(phaseLabels):len(phaseLabels)], "resumed"),
+			constLabels,
+		),
+		quicHandshake: prometheus.NewDesc(
+			"quic_handshake_time",
+			"A gauge of the QUIC handshake duration of HTTP/3 probes, including the TLS handshake it carries(ms)",
+			phaseLabels,
+			constLabels,
+		),
+		wsRoundtrip: prometheus.NewDesc(
+			"ws_roundtrip_time",
+			"A gauge of the round-trip time of a WebSocket message echoed by the server(ms)",
+			nil,
+			constLabels,
+		),
+		grpcRPCTime: prometheus.NewDesc(
+			"grpc_rpc_time",
+			"A gauge of the duration of the gRPC health check RPC(ms)",
+			nil,
+			constLabels,
+		),
+		grpcStatusCode: prometheus.NewDesc(
+			"probe_grpc_status_code",
+			"The grpc-status of the gRPC health check",
 			nil,
+			constLabels,
+		),
+		grpcHealthResponse: prometheus.NewDesc(
+			"probe_grpc_healthcheck_response",
+			"The serving status of the gRPC health check response, set to 1 for the status",
+			[]string{"serving_status"},
+			constLabels,
+		),
+		preDNS: prometheus.NewDesc(
+			"pre_dns_time",
+			"A gauge of the duration between request start and DNS lookup start(ms)",
+			phaseLabels,
+			constLabels,
+		),
+		preTLS: prometheus.NewDesc(
+			"pre_tls_time",
+			"A gauge of the duration between TCP connect and TLS handshake start(ms)",
+			phaseLabels,
+			constLabels,
+		),
+		connectReady: prometheus.NewDesc(
+			"connect_ready_time",
+			"A gauge of the duration from the request start until a connection was obtained(ms)",
+			phaseLabels,
+			constLabels,
 		),
 		serverProcessing: prometheus.NewDesc(
 			"server_processing_time",
 			"A gauge of the server processing duration(ms)",
-			[]string{"status_code"},
-			nil,
+			phaseLabels,
+			constLabels,
 		),
 		contentTransfer: prometheus.NewDesc(
 			"content_transfer_time",
 			"A gauge of the content transfer duration(ms)",
-			[]string{"status_code"},
-			nil,
+			phaseLabels,
+			constLabels,
 		),
 		ttfb: prometheus.NewDesc(
 			"ttfb",
 			"A gauge of the content transfer duration(ms)",
-			[]string{"status_code"},
+			phaseLabels,
+			constLabels,
+		),
+		coldTTFB: prometheus.NewDesc(
+			"cold_ttfb",
+			"A gauge of the TTFB of the warmup request on a cold connection(ms)",
+			phaseLabels,
+			constLabels,
+		),
+		redirectTime: prometheus.NewDesc(
+			"redirect_time",
+			"A gauge of the cumulative duration of redirect hops before the final response(ms)",
+			phaseLabels,
+			constLabels,
+		),
+		redirects: prometheus.NewDesc(
+			"probe_http_redirects",
+			"Number of redirects followed by the probe",
+			nil,
+			constLabels,
+		),
+		redirectHopTime: prometheus.NewDesc(
+			"probe_http_redirect_hop_time",
+			"A gauge of the duration of each redirect hop until its redirect response, by its position and URL(ms)",
+			[]string{"hop", "url"},
+			constLabels,
+		),
+		failureReason: prometheus.NewDesc(
+			"probe_failure_reason",
+			"Why the probe failed, set to 1 for the failure reason",
+			[]string{"reason"},
+			constLabels,
+		),
+		bodySHA256: prometheus.NewDesc(
+			"probe_body_sha256",
+			"SHA-256 of the response body, set to 1 for the current hash",
+			[]string{"sha256"},
+			constLabels,
+		),
+		checksumMatch: prometheus.NewDesc(
+			"probe_body_checksum_match",
+			"Whether the SHA-256 of the response body is the expected_sha256",
+			nil,
+			constLabels,
+		),
+		attemptsTotal: prometheus.NewDesc(
+			"probe_attempts_total",
+			"A counter of the attempts of the probe request, counting retries and samples but not warmup or redirects",
+			nil,
+			constLabels,
+		),
+		requestsTotal: prometheus.NewDesc(
+			"probe_requests_total",
+			"A counter of the HTTP requests actually made by the probe, including warmup and redirects",
+			nil,
+			constLabels,
+		),
+		connectSuccess: prometheus.NewDesc(
+			"probe_connect_success",
+			"Whether a connection to the target was established, regardless of the HTTP result",
+			nil,
+			constLabels,
+		),
+		accountingGap: prometheus.NewDesc(
+			"probe_phase_accounting_gap_seconds",
+			"Total probe duration minus the sum of the DNS, TCP, TLS, server processing and content transfer phases",
+			nil,
+			constLabels,
+		),
+		tlsResumed: prometheus.NewDesc(
+			"probe_tls_resumed",
+			"Whether the TLS session was resumed instead of a full handshake",
+			nil,
+			constLabels,
+		),
+		alpnInfo: prometheus.NewDesc(
+			"probe_tls_alpn_info",
+			"Protocol negotiated via TLS ALPN, set to 1 for the negotiated protocol",
+			[]string{"protocol"},
+			constLabels,
+		),
+		tlsVersionInfo: prometheus.NewDesc(
+			"probe_tls_version_info",
+			"Negotiated TLS version, set to 1 for the version",
+			[]string{"tls_version"},
+			constLabels,
+		),
+		tlsCipherInfo: prometheus.NewDesc(
+			"probe_tls_cipher_info",
+			"Negotiated TLS cipher suite, set to 1 for the cipher suite",
+			[]string{"cipher"},
+			constLabels,
+		),
+		tlsCertInfo: prometheus.NewDesc(
+			"probe_tls_cert_info",
+			"Subject, issuer, serial number, signature algorithm and public key of the server certificate, set to 1",
+			[]string{"subject", "issuer", "serial", "signature_algorithm", "key_type", "key_size"},
+			constLabels,
+		),
+		tlsEarliestExpiry: prometheus.NewDesc(
+			"probe_ssl_earliest_cert_expiry",
+			"Earliest expiry of the certificates sent by the server in unixtime",
+			nil,
+			constLabels,
+		),
+		tlsExpiryDays: prometheus.NewDesc(
+			"probe_ssl_earliest_cert_expiry_days",
+			"Days until the earliest expiry of the certificates sent by the server",
+			nil,
+			constLabels,
+		),
+		certLifetimeFraction: prometheus.NewDesc(
+			"probe_tls_cert_lifetime_fraction",
+			"Fraction of the validity period of the server certificate remaining",
+			nil,
+			constLabels,
+		),
+		sourceIPInfo: prometheus.NewDesc(
+			"probe_source_ip_info",
+			"Local address the probe connected from, set to 1 for the address",
+			[]string{"source_ip"},
+			constLabels,
+		),
+		httpVersionInfo: prometheus.NewDesc(
+			"probe_http_version_info",
+			"HTTP version of the response, set to 1 for the version",
+			[]string{"http_version"},
+			constLabels,
+		),
+		httpVersion: prometheus.NewDesc(
+			"probe_http_version",
+			"HTTP version of the response, e.g. 1.1 or 2",
+			nil,
+			constLabels,
+		),
+		ipProtocol: prometheus.NewDesc(
+			"probe_ip_protocol",
+			"IP protocol of the connection of the probe, 4 or 6",
+			nil,
+			constLabels,
+		),
+		requestIDInfo: prometheus.NewDesc(
+			"probe_request_id_info",
+			"X-Request-ID sent with the probe request, set to 1 for the ID",
+			[]string{"request_id"},
+			constLabels,
+		),
+		contentLengthMismatch: prometheus.NewDesc(
+			"probe_content_length_mismatch",
+			"Whether the response body size differs from its Content-Length header",
+			nil,
+			constLabels,
+		),
+		setCookieCount: prometheus.NewDesc(
+			"probe_set_cookie_count",
+			"Number of Set-Cookie headers in the response",
+			nil,
+			constLabels,
+		),
+		clockSkew: prometheus.NewDesc(
+			"probe_server_clock_skew_seconds",
+			"Date response header minus the local time the response arrived, positive if the server clock is ahead",
+			nil,
+			constLabels,
+		),
+		connectionsOpened: prometheus.NewDesc(
+			"probe_connections_opened",
+			"Number of TCP connections opened by the probe, including for redirects",
+			nil,
+			constLabels,
+		),
+		httpStatusCode: prometheus.NewDesc(
+			"probe_http_status_code",
+			"Status code of the final response, 0 if there was none",
+			nil,
+			constLabels,
+		),
+		retryAfter: prometheus.NewDesc(
+			"probe_retry_after_seconds",
+			"How long a 503 response asks to wait before retrying, from its Retry-After header",
+			nil,
+			constLabels,
+		),
+		alertsSilenced: prometheus.NewDesc(
+			"probe_alerts_silenced",
+			"Whether Slack alerts of the target are silenced for maintenance via /silence",
+			nil,
+			constLabels,
+		),
+		dnsCacheHit: prometheus.NewDesc(
+			"probe_dns_cache_hit",
+			"Whether no DNS lookup was made, e.g. as a connection was reused or the target is an IP, so that dns_lookup_time is 0",
+			nil,
+			constLabels,
+		),
+		responseSane: prometheus.NewDesc(
+			"probe_response_sane",
+			"Whether the Content-Length of the response is consistent with its status",
+			nil,
+			constLabels,
+		),
+		responseSize: prometheus.NewDesc(
+			"probe_response_size_bytes",
+			"Response size measured in head_for_size mode, by the method it was measured with",
+			[]string{"size_method"},
+			constLabels,
+		),
+		redirectCorrect: prometheus.NewDesc(
+			"probe_redirect_correct",
+			"Whether the response is a redirect to the expected location",
+			nil,
+			constLabels,
+		),
+		contentMatch: prometheus.NewDesc(
+			"probe_content_match",
+			"Whether the response body matches the expected content",
+			nil,
+			constLabels,
+		),
+		jsonAssertion: prometheus.NewDesc(
+			"probe_json_assertion",
+			"Whether all json_assert assertions hold for the response body",
+			nil,
+			constLabels,
+		),
+		jsonAssertionResult: prometheus.NewDesc(
+			"probe_json_assertion_result",
+			"Whether a json_assert assertion holds for the response body",
+			[]string{"expression"},
+			constLabels,
+		),
+		jsonValue: prometheus.NewDesc(
+			"probe_json_value",
+			"Number at the path of a json_assert assertion in the response body, 1 or 0 for booleans",
+			[]string{"json_path"},
+			constLabels,
+		),
+		contentLength: prometheus.NewDesc(
+			"probe_http_content_length",
+			"Content-Length of the response, -1 if unknown",
+			nil,
+			constLabels,
+		),
+		uncompressedBodyBytes: prometheus.NewDesc(
+			"probe_http_uncompressed_body_bytes",
+			"Size of the response body after decoding its Content-Encoding, up to -max-body-bytes",
+			nil,
+			constLabels,
+		),
+		downloadThroughput: prometheus.NewDesc(
+			"probe_http_download_throughput_bytes_per_second",
+			"Response body bytes read off the wire per second of content_transfer_time",
+			nil,
+			constLabels,
+		),
+		compressed: prometheus.NewDesc(
+			"probe_http_compressed",
+			"Whether the response body was compressed with a Content-Encoding that was decoded, gzip or br",
+			nil,
+			constLabels,
+		),
+		compressedBodyBytes: prometheus.NewDesc(
+			"probe_http_compressed_body_bytes",
+			"Size of the compressed response body on the wire, up to -max-body-bytes",
+			nil,
+			constLabels,
+		),
+		decodeTime: prometheus.NewDesc(
+			"probe_http_decode_time",
+			"Time spent decoding the compressed response body, excluding waiting for the network(ms)",
+			nil,
+			constLabels,
+		),
+		rangeSize: prometheus.NewDesc(
+			"probe_range_size_bytes",
+			"Size of the body received for the requested range",
+			nil,
+			constLabels,
+		),
+		offHostRedirect: prometheus.NewDesc(
+			"probe_redirect_off_host",
+			"Set to 1 for the host of a redirect rejected by same_host_only",
+			[]string{"host"},
+			constLabels,
+		),
+		notModified: prometheus.NewDesc(
+			"probe_not_modified",
+			"Whether a conditional request got 304 Not Modified",
+			nil,
+			constLabels,
+		),
+		altSvcH3: prometheus.NewDesc(
+			"probe_alt_svc_h3",
+			"Whether the Alt-Svc response header advertises HTTP/3",
 			nil,
+			constLabels,
+		),
+		hstsEnabled: prometheus.NewDesc(
+			"probe_hsts_enabled",
+			"Whether an https response enables HSTS with a positive max-age",
+			nil,
+			constLabels,
+		),
+		hstsMaxAge: prometheus.NewDesc(
+			"probe_hsts_max_age_seconds",
+			"The max-age of the Strict-Transport-Security header of an https response",
+			nil,
+			constLabels,
 		),
 	}
 }
 
 func (c *httpStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.probeSuccess
+	ch <- c.probeDuration
+	ch <- c.probeTimestamp
 	ch <- c.dnsLookup
 	ch <- c.tcpConnection
+	ch <- c.tcpHandshakeSlow
+	ch <- c.phaseOK
 	ch <- c.tlsHandshake
+	ch <- c.quicHandshake
+	ch <- c.preDNS
+	ch <- c.wsRoundtrip
+	ch <- c.grpcRPCTime
+	ch <- c.grpcStatusCode
+	ch <- c.grpcHealthResponse
+	ch <- c.preTLS
+	ch <- c.connectReady
 	ch <- c.serverProcessing
 	ch <- c.contentTransfer
 	ch <- c.ttfb
+	ch <- c.coldTTFB
+	ch <- c.redirectTime
+	ch <- c.redirects
+	ch <- c.redirectHopTime
+	ch <- c.altSvcH3
+	ch <- c.hstsEnabled
+	ch <- c.hstsMaxAge
+	ch <- c.failureReason
+	ch <- c.bodySHA256
+	ch <- c.checksumMatch
+	ch <- c.requestsTotal
+	ch <- c.attemptsTotal
+	ch <- c.connectSuccess
+	ch <- c.accountingGap
+	ch <- c.tlsResumed
+	ch <- c.alpnInfo
+	ch <- c.tlsVersionInfo
+	ch <- c.tlsCertInfo
+	ch <- c.tlsEarliestExpiry
+	ch <- c.tlsExpiryDays
+	ch <- c.tlsCipherInfo
+	ch <- c.certLifetimeFraction
+	ch <- c.sourceIPInfo
+	ch <- c.httpVersionInfo
+	ch <- c.httpVersion
+	ch <- c.ipProtocol
+	ch <- c.requestIDInfo
+	ch <- c.contentLengthMismatch
+	ch <- c.setCookieCount
+	ch <- c.clockSkew
+	ch <- c.responseSane
+	ch <- c.connectionsOpened
+	ch <- c.dnsCacheHit
+	ch <- c.alertsSilenced
+	ch <- c.retryAfter
+	ch <- c.httpStatusCode
+	ch <- c.responseSize
+	ch <- c.redirectCorrect
+	ch <- c.offHostRedirect
+	ch <- c.notModified
+	ch <- c.rangeSize
+	ch <- c.contentLength
+	ch <- c.uncompressedBodyBytes
+	ch <- c.downloadThroughput
+	ch <- c.compressed
+	ch <- c.compressedBodyBytes
+	ch <- c.decodeTime
+	ch <- c.contentMatch
+	ch <- c.jsonAssertion
+	ch <- c.jsonAssertionResult
+	ch <- c.jsonValue
+	c.describeSamples(ch)
 }
 
 func (c *httpStatsCollector) Collect(ch chan<- prometheus.Metric) {
-	s, resp, err := c.visit()
-	if err != nil {
-		log.Printf("URL visit error: %s", err)
+	if !c.breaker.allow(breakerKey(c.url, c.module)) {
+		sendGauge(ch, c.probeSuccess, 0)
+		sendGauge(ch, c.failureReason, 1, "circuit_open")
 		return
 	}
-	defer resp.Body.Close()
 
-	statusCode := "unknown"
-	if resp.StatusCode >= 100 && resp.StatusCode <= 199 {
-		statusCode = "1xx"
+	if c.tcp {
+		c.collectTCP(ch)
+		return
 	}
-	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
-		statusCode = "2xx"
+	if c.tlsOnly {
+		c.collectTLSOnly(ch)
+		return
 	}
-	if resp.StatusCode >= 300 && resp.StatusCode <= 399 {
-		statusCode = "3xx"
+	if c.wsEcho {
+		c.collectWebSocket(ch)
+		return
 	}
-	if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
-		statusCode = "4xx"
+	if c.grpc {
+		c.collectGRPC(ch)
+		return
 	}
-	if resp.StatusCode >= 500 && resp.StatusCode <= 599 {
-		statusCode = "5xx"
+
+	var bodyMatch *regexp.Regexp
+	if c.bodyMatchFile != "" {
+		// Read on every probe so that edits apply without a restart
+		var err error
+		bodyMatch, err = readBodyMatch(c.bodyMatchFile)
+		if err != nil {
+			// Not the target's fault, so the breaker doesn't hear of it
+			log.Printf("Body match file error: %s", err)
+			sendGauge(ch, c.probeSuccess, 0)
+			sendGauge(ch, c.failureReason, 1, "config")
+			return
+		}
 	}
 
-	dnsLookupMetric, err := prometheus.NewConstMetric(
-		c.dnsLookup,
-		prometheus.GaugeValue,
-		ns2ms(s.dnsLookup()),
-		statusCode,
-	)
+	start := time.Now()
+	s, resp, err := c.visit()
+	var body bodyResult
+	if err == nil {
+		defer resp.Body.Close()
+		if !c.headOnlyTiming {
+			// Read right away, so that content_transfer_time covers the
+			// body rather than ending with the headers
+			hashMaxBytes := c.bodyHashMaxBytes
+			if c.expectedSHA256 != "" {
+				hashMaxBytes = c.maxBodyBytes
+			}
+			body = drainBody(resp.Body, resp.Header.Get("Content-Encoding"), c.maxBodyBytes, hashMaxBytes, c.keepBody())
+			s.Finish = time.Now()
+		}
+	}
+	sendGauge(ch, c.probeDuration, time.Since(start).Seconds())
+	if !s.Start.IsZero() {
+		sendGauge(ch, c.probeTimestamp, float64(s.Start.UnixNano())/1e9)
+	}
+	// Sent last, so that they count the requests of the samples too
+	defer func() {
+		sendCounter(ch, c.requestsTotal, float64(c.requests))
+		sendCounter(ch, c.attemptsTotal, float64(c.attempts))
+	}()
+	sendGauge(ch, c.connectSuccess, bool2float(!s.GotConn.IsZero()))
+	sendGauge(ch, c.connectionsOpened, float64(s.connections))
+	sendGauge(ch, c.redirects, float64(len(s.hops)))
+	for i, hop := range s.hops {
+		sendGauge(ch, c.redirectHopTime, ns2ms(hop.duration), strconv.Itoa(i+1), hop.url)
+	}
+	if s.requestID != "" {
+		log.Printf("Probe of %s sent X-Request-ID %s", c.url, s.requestID)
+		sendGauge(ch, c.requestIDInfo, 1, s.requestID)
+	}
+	sendGauge(ch, c.alertsSilenced, bool2float(alertSilences.active(c.url)))
 	if err != nil {
-		log.Printf("dnsLookup metric generation error: %s", err)
+		c.failureLog.failed(c.url, err)
+		alertSlackError(c.url, err)
+		sendGauge(ch, c.httpStatusCode, 0)
+		if c.hasPhaseLabel("result") {
+			// The result label is what tells these apart from successes
+			c.sendPhases(ch, s, c.phaseLabelValues(0, probeResult(err)))
+		}
+		var offHostErr *offHostError
+		if errors.As(err, &offHostErr) {
+			sendGauge(ch, c.offHostRedirect, 1, offHostErr.host)
+		}
+		c.statsd.send(c.url, s, false)
+		c.sendResult(ch, failureReason(err))
 		return
 	}
-	ch <- dnsLookupMetric
+	c.failureLog.succeeded(c.url)
 
-	tcpConnectionMetric, err := prometheus.NewConstMetric(
-		c.tcpConnection,
-		prometheus.GaugeValue,
-		ns2ms(s.tcpConnection()),
-		statusCode,
-	)
-	if err != nil {
-		log.Printf("tcpConnection metric generation error: %s", err)
-		return
+	sendGauge(ch, c.httpStatusCode, float64(resp.StatusCode))
+
+	sendGauge(ch, c.accountingGap, s.accountingGap().Seconds())
+	sendGauge(ch, c.dnsCacheHit, bool2float(s.DNSStart.IsZero()))
+	sendGauge(ch, c.httpVersionInfo, 1, resp.Proto)
+	sendGauge(ch, c.httpVersion, float64(resp.ProtoMajor)+float64(resp.ProtoMinor)/10)
+	if s.sourceIP != nil {
+		sendGauge(ch, c.sourceIPInfo, 1, s.sourceIP.String())
 	}
-	ch <- tcpConnectionMetric
+	if s.ipProtocol != 0 {
+		sendGauge(ch, c.ipProtocol, float64(s.ipProtocol))
+	}
+	c.collectTLS(ch, s)
 
-	tlsHandshakeMetric, err := prometheus.NewConstMetric(
-		c.tlsHandshake,
-		prometheus.GaugeValue,
-		ns2ms(s.tlsHandshake()),
-		statusCode,
-	)
-	if err != nil {
-		log.Printf("tlsHandshke metric generation error: %s", err)
-		return
+	sendGauge(ch, c.altSvcH3, bool2float(advertisesH3(resp.Header.Get("Alt-Svc"))))
+	sendGauge(ch, c.responseSane, bool2float(responseSane(resp, c.method, c.expectBody)))
+	// Browsers ignore the header over plain HTTP, and only honor the first one
+	maxAge, ok := hstsMaxAge(resp.Header.Get("Strict-Transport-Security"))
+	sendGauge(ch, c.hstsEnabled, bool2float(resp.TLS != nil && ok && maxAge > 0))
+	if resp.TLS != nil && ok {
+		sendGauge(ch, c.hstsMaxAge, float64(maxAge))
+	}
+	sendGauge(ch, c.setCookieCount, float64(len(resp.Header["Set-Cookie"])))
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		// Date has a resolution of a second, so skews below that are noise
+		sendGauge(ch, c.clockSkew, date.Sub(s.GotFirstResponseByte).Round(time.Second).Seconds())
+	}
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		if d, ok := retryAfter(resp.Header.Get("Retry-After"), s.GotFirstResponseByte); ok {
+			sendGauge(ch, c.retryAfter, d.Seconds())
+		}
+	}
+	if s.sizeMethod != "" {
+		sendGauge(ch, c.responseSize, float64(s.size), s.sizeMethod)
 	}
-	ch <- tlsHandshakeMetric
 
-	serverProcessingMetric, err := prometheus.NewConstMetric(
-		c.serverProcessing,
-		prometheus.GaugeValue,
-		ns2ms(s.serverProcessing()),
-		statusCode,
-	)
-	if err != nil {
-		log.Printf("serverProcessing metric generation error: %s", err)
-		return
+	sendGauge(ch, c.contentLength, float64(resp.ContentLength))
+	if !c.headOnlyTiming {
+		if body.err != nil {
+			log.Printf("Body read error: %s", body.err)
+		}
+		sendGauge(ch, c.uncompressedBodyBytes, float64(body.decompressedBytes))
+		if d := s.contentTransfer(); d > 0 && body.err == nil {
+			sendGauge(ch, c.downloadThroughput, float64(body.bytes)/d.Seconds())
+		}
+		sendGauge(ch, c.compressed, bool2float(body.decoded))
+		if body.decoded {
+			sendGauge(ch, c.compressedBodyBytes, float64(body.bytes))
+			sendGauge(ch, c.decodeTime, ns2ms(body.decodeTime))
+		}
+		// Hashed beyond -body-hash-max-bytes only to be verified
+		if body.sha256 != "" && body.decompressedBytes <= c.bodyHashMaxBytes {
+			sendGauge(ch, c.bodySHA256, 1, body.sha256)
+		}
+		if mismatch, ok := contentLengthMismatch(resp.ContentLength, body); ok {
+			sendGauge(ch, c.contentLengthMismatch, bool2float(mismatch))
+		}
+		if c.byteRange != "" {
+			sendGauge(ch, c.rangeSize, float64(body.bytes))
+		}
+	}
+	if c.ifNoneMatch != "" || c.ifModifiedSince != "" {
+		sendGauge(ch, c.notModified, bool2float(resp.StatusCode == http.StatusNotModified))
 	}
-	ch <- serverProcessingMetric
 
-	contentTransferMetric, err := prometheus.NewConstMetric(
-		c.contentTransfer,
-		prometheus.GaugeValue,
-		ns2ms(s.contentTransfer()),
-		statusCode,
-	)
-	if err != nil {
-		log.Printf("contentTransfer metric generation error: %s", err)
+	// Success criteria beyond the request itself. The first failing
+	// criterion is reported as the failure reason.
+	failure := ""
+	if !c.statusCodeValid(resp.StatusCode) {
+		failure = "status_code"
+	}
+	if failedHeaderMatch(resp.Header, c.headerMatches, c.headerForbidden) != "" && failure == "" {
+		failure = "header_match"
+	}
+	if c.minBodyBytes > 0 && body.decompressedBytes < c.minBodyBytes && failure == "" {
+		failure = "min_body_bytes"
+	}
+	if bodyMatch != nil {
+		match := bodyMatch.Match(body.content)
+		sendGauge(ch, c.contentMatch, bool2float(match))
+		if !match && failure == "" {
+			failure = "body_match"
+		}
+	}
+	for _, re := range c.bodyMatches {
+		if !re.Match(body.content) && failure == "" {
+			failure = "body_match"
+		}
+	}
+	for _, re := range c.bodyForbidden {
+		if re.Match(body.content) && failure == "" {
+			failure = "body_forbidden_match"
+		}
+	}
+	if c.expectedSHA256 != "" {
+		// Unhashed bodies, e.g. larger than -max-body-bytes, can't be verified
+		match := body.sha256 == c.expectedSHA256
+		sendGauge(ch, c.checksumMatch, bool2float(match))
+		if !match && failure == "" {
+			failure = "checksum"
+		}
+	}
+	if len(c.jsonAssertions) > 0 {
+		results, err := evalJSONAssertions(body.content, c.jsonAssertions)
+		if err != nil {
+			log.Printf("JSON assertion error: %s", err)
+		}
+		ok := err == nil
+		// Assertions may repeat, or share a path
+		sentExprs, sentPaths := map[string]bool{}, map[string]bool{}
+		for i, a := range c.jsonAssertions {
+			var r jsonAssertionResult
+			if results != nil {
+				r = results[i]
+			}
+			if r.err != nil {
+				log.Printf("JSON assertion error: %s", r.err)
+			}
+			ok = ok && r.ok
+			if !sentExprs[a.expr] {
+				sendGauge(ch, c.jsonAssertionResult, bool2float(r.ok), a.expr)
+				sentExprs[a.expr] = true
+			}
+			if r.numeric && !sentPaths[a.jsonPath] {
+				sendGauge(ch, c.jsonValue, r.value, a.jsonPath)
+				sentPaths[a.jsonPath] = true
+			}
+		}
+		sendGauge(ch, c.jsonAssertion, bool2float(ok))
+		if !ok && failure == "" {
+			failure = "json_assertion"
+		}
+	}
+	if c.expectLocation != nil {
+		correct := resp.StatusCode >= 300 && resp.StatusCode <= 399 &&
+			c.expectLocation.MatchString(resp.Header.Get("Location"))
+		sendGauge(ch, c.redirectCorrect, bool2float(correct))
+		if !correct && failure == "" {
+			failure = "redirect_location"
+		}
+	}
+
+	if c.samples > 1 {
+		c.collectSamples(ch, s)
+	}
+
+	// The status code is a success criterion, so that probe_success, the
+	// failure reason, the result label, the breaker and statsd all agree
+	result := "success"
+	if failure != "" {
+		result = "http_error"
+	}
+	c.sendPhases(ch, s, c.phaseLabelValues(resp.StatusCode, result))
+	c.sendPhaseSLOs(ch, s)
+	c.statsd.send(c.url, s, failure == "")
+	alertSlack(c.url, resp.StatusCode, s.ttfb(), failure)
+	c.sendResult(ch, failure)
+}
+
+// statusCodeValid reports whether code passes the probe. Like blackbox
+// exporter, only 2xx does by default, besides the responses a probe asks
+// for: 304 to a conditional request, and the redirect expect_location checks.
+func (c *httpStatsCollector) statusCodeValid(code int) bool {
+	if len(c.validStatusCodes) > 0 {
+		return containsInt(c.validStatusCodes, code)
+	}
+	switch {
+	case code >= 200 && code <= 299:
+		return true
+	case code == http.StatusNotModified:
+		return c.ifNoneMatch != "" || c.ifModifiedSince != ""
+	case code >= 300 && code <= 399:
+		return c.expectLocation != nil
+	}
+	return false
+}
+
+// keepBody reports whether a check needs the body content.
+func (c *httpStatsCollector) keepBody() bool {
+	return c.bodyMatchFile != "" || len(c.jsonAssertions) > 0 ||
+		len(c.bodyMatches) > 0 || len(c.bodyForbidden) > 0
+}
+
+// sendPhases sends the phase metrics of s labeled with labelValues.
+func (c *httpStatsCollector) sendPhases(ch chan<- prometheus.Metric, s stats, labelValues []string) {
+	sendGauge(ch, c.dnsLookup, ns2ms(s.dnsLookup()), labelValues...)
+	sendGauge(ch, c.tcpConnection, ns2ms(s.tcpConnection()), labelValues...)
+	if c.tcpSlowThreshold > 0 {
+		sendGauge(ch, c.tcpHandshakeSlow, bool2float(s.tcpConnection() > c.tcpSlowThreshold), labelValues...)
+	}
+	c.sendTLSHandshake(ch, s, labelValues)
+	sendGauge(ch, c.preDNS, ns2ms(s.preDNS()), labelValues...)
+	sendGauge(ch, c.preTLS, ns2ms(s.preTLS()), labelValues...)
+	sendGauge(ch, c.connectReady, ns2ms(s.connectReady()), labelValues...)
+	sendGauge(ch, c.serverProcessing, ns2ms(s.serverProcessing()), labelValues...)
+	sendGauge(ch, c.contentTransfer, ns2ms(s.contentTransfer()), labelValues...)
+	sendGauge(ch, c.ttfb, ns2ms(s.ttfb()), labelValues...)
+	sendGauge(ch, c.redirectTime, ns2ms(s.redirectTime), labelValues...)
+	if c.warmup {
+		sendGauge(ch, c.coldTTFB, ns2ms(s.coldTTFB), labelValues...)
+	}
+}
+
+// statusBucket returns the class of a status code, e.g. 2xx.
+func statusBucket(code int) string {
+	if code < 100 || code > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// sendTLSHandshake sends tls_handshake_time, which is labeled by resumption
+// besides labelValues as resumed handshakes are much faster.
+func (c *httpStatsCollector) sendTLSHandshake(ch chan<- prometheus.Metric, s stats, labelValues []string) {
+	if c.http3 {
+		sendGauge(ch, c.quicHandshake, ns2ms(s.tlsHandshake()), labelValues...)
 		return
 	}
-	ch <- contentTransferMetric
+	labelValues = append(labelValues[:len(labelValues):len(labelValues)], strconv.FormatBool(s.tlsResumed))
+	sendGauge(ch, c.tlsHandshake, ns2ms(s.tlsHandshake()), labelValues...)
+}
 
-	ttfbMetric, err := prometheus.NewConstMetric(
-		c.ttfb,
-		prometheus.GaugeValue,
-		ns2ms(s.ttfb()),
-		statusCode,
-	)
+func (c *httpStatsCollector) hasPhaseLabel(name string) bool {
+	for _, l := range c.phaseLabels {
+		if l == name {
+			return true
+		}
+	}
+	return false
+}
+
+// phaseLabelValues returns the values for c.phaseLabels. A code of 0 means
+// there was no response.
+func (c *httpStatsCollector) phaseLabelValues(code int, result string) []string {
+	values := make([]string, len(c.phaseLabels))
+	for i, name := range c.phaseLabels {
+		switch name {
+		case "status_code":
+			values[i] = statusBucket(code)
+		case "code":
+			values[i] = "unknown"
+			if code != 0 {
+				values[i] = strconv.Itoa(code)
+			}
+		case "result":
+			values[i] = result
+		}
+	}
+	return values
+}
+
+// sendResult sends probe_success, and the failure reason unless reason is
+// empty, and records the result in the circuit breaker.
+func (c *httpStatsCollector) sendResult(ch chan<- prometheus.Metric, reason string) {
+	c.breaker.record(breakerKey(c.url, c.module), reason == "")
+	sendGauge(ch, c.probeSuccess, bool2float(reason == ""))
+	if reason != "" {
+		sendGauge(ch, c.failureReason, 1, reason)
+	}
+}
+
+// sendGauge builds a gauge from desc and sends it to ch. Generation errors
+// are logged and the metric is skipped.
+func sendGauge(ch chan<- prometheus.Metric, desc *prometheus.Desc, value float64, labelValues ...string) {
+	sendConstMetric(ch, desc, prometheus.GaugeValue, value, labelValues...)
+}
+
+// sendCounter is like sendGauge, but for counters.
+func sendCounter(ch chan<- prometheus.Metric, desc *prometheus.Desc, value float64, labelValues ...string) {
+	sendConstMetric(ch, desc, prometheus.CounterValue, value, labelValues...)
+}
+
+func sendConstMetric(ch chan<- prometheus.Metric, desc *prometheus.Desc, valueType prometheus.ValueType, value float64, labelValues ...string) {
+	m, err := prometheus.NewConstMetric(desc, valueType, value, labelValues...)
 	if err != nil {
-		log.Printf("ttfb metric generation error: %s", err)
+		log.Printf("%s metric generation error: %s", desc, err)
 		return
 	}
-	ch <- ttfbMetric
+	ch <- m
 }
 
 func ns2ms(d time.Duration) float64 {
 	return float64(d) / float64(time.Millisecond)
 }
 
+// probeResult classifies a visit error for the result label.
+func probeResult(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case isTLSError(err):
+		return "tls"
+	}
+	return "error"
+}
+
+// failureReason classifies a visit error for the probe_failure_reason metric.
+func failureReason(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsTimeout {
+		return "dns_timeout"
+	}
+	if errors.Is(err, errRequestBudget) {
+		return "request_budget"
+	}
+	if errors.Is(err, errAuth) {
+		return "auth"
+	}
+	if errors.Is(err, errTLSDisabled) {
+		return "tls_disabled"
+	}
+	if errors.Is(err, errTooManyRedirects) {
+		return "too_many_redirects"
+	}
+	if errors.Is(err, errNoPreferredIP) {
+		return "ip_protocol"
+	}
+	var offHostErr *offHostError
+	if errors.As(err, &offHostErr) {
+		return "off_host_redirect"
+	}
+	if errors.Is(err, syscall.EADDRNOTAVAIL) {
+		return "bind"
+	}
+	// net/http doesn't export an error value for this
+	if strings.Contains(err.Error(), "server response headers exceeded") {
+		return "header_too_large"
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return "connection_refused"
+	}
+	if errors.Is(err, syscall.ECONNRESET) {
+		return "connection_reset"
+	}
+	if result := probeResult(err); result != "error" {
+		return result
+	}
+	return "unknown"
+}
+
+func containsInt(s []int, v int) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func bool2float(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// advertisesH3 reports whether an Alt-Svc header value advertises HTTP/3,
+// either final ("h3") or a draft version ("h3-29").
+func advertisesH3(altSvc string) bool {
+	for _, alt := range strings.Split(altSvc, ",") {
+		// alt is e.g. `h3=":443"; ma=86400`
+		protocol := strings.TrimSpace(strings.SplitN(alt, "=", 2)[0])
+		if protocol == "h3" || strings.HasPrefix(protocol, "h3-") {
+			return true
+		}
+	}
+	return false
+}
+
+// responseSane reports whether resp is free of the Content-Length protocol
+// violations of broken servers and proxies: a Content-Length on a 204 or 304,
+// or, if expectBody, a 200 declaring an empty body.
+func responseSane(resp *http.Response, method string, expectBody bool) bool {
+	_, hasLength := resp.Header["Content-Length"]
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusNotModified:
+		return !hasLength
+	case http.StatusOK:
+		return !(expectBody && method != "HEAD" && hasLength && resp.Header.Get("Content-Length") == "0")
+	}
+	return true
+}
+
+// retryAfter parses a Retry-After header, either delay-seconds or an
+// HTTP-date, into the delay from now. Dates in the past give 0.
+func retryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(header, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	if d := date.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// hstsMaxAge parses the max-age directive of a Strict-Transport-Security
+// header value. ok is false if there is no valid max-age, which makes the
+// header invalid.
+func hstsMaxAge(sts string) (maxAge int64, ok bool) {
+	for _, directive := range strings.Split(sts, ";") {
+		kv := strings.SplitN(strings.TrimSpace(directive), "=", 2)
+		if len(kv) != 2 || !strings.EqualFold(strings.TrimSpace(kv[0]), "max-age") {
+			continue
+		}
+		v := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		maxAge, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || maxAge < 0 {
+			return 0, false
+		}
+		return maxAge, true
+	}
+	return 0, false
+}
+
+var (
+	debug            = flag.Bool("debug", false, "Log request and response headers of every probe. Credentials are redacted")
+	noTLS            = flag.Bool("no-tls", false, "Reject https targets and redirects so that only plaintext requests are made")
+	region           = flag.String("region", "", "Region of this exporter, added as a region label to all metrics")
+	maxPaths         = flag.Int("max-paths", 10, "Maximum number of paths params of a probe")
+	pathsConcurrency = flag.Int("paths-concurrency", 1, "Number of paths of a probe probed at a time, each over its own connection")
+	maxRequests      = flag.Int("max-requests-per-scrape", 0, "Maximum HTTP requests a single scrape may make, including warmup and redirects. 0 means no limit")
+	requestID        = flag.Bool("request-id", false, "Send a random X-Request-ID with each probe request, logging it and exposing it on probe_request_id_info. Every probe creates a new series")
+	openMetrics      = flag.Bool("openmetrics", false, "Serve metrics in the OpenMetrics format regardless of the Accept header of the scraper")
+	timeoutHeadroom  = flag.Duration("timeout-headroom", 500*time.Millisecond, "Time taken off the X-Prometheus-Scrape-Timeout-Seconds of a scrape for the probe timeout, when there is no timeout param")
+	statusLabelMode  = flag.String("status-label-mode", "bucket", "Status label of the phase metrics: bucket for status_code=\"2xx\", exact for code=\"200\", or both")
+	resultLabel      = flag.Bool("result-label", false, "Add a result label (success, timeout, dns, tls, http_error, error) to the phase metrics, which are then also sent for failed probes")
+	tcpNoDelay       = flag.Bool("tcp-nodelay", true, "Set TCP_NODELAY on probe connections, disabling Nagle's algorithm as Go does by default")
+	tcpSlowMs        = flag.Int("tcp-slow-threshold-ms", 0, "TCP handshake duration above which tcp_handshake_slow is set(ms). 0 disables the metric")
+	maxHeaderBytes   = flag.Int64("max-response-header-bytes", 0, "Maximum response header size(bytes). 0 uses the net/http default")
+	tlsSessionCache  = flag.Bool("tls-session-cache", false, "Share a TLS session cache between probes so that handshakes can be resumed")
+	breakerFailures  = flag.Int("circuit-breaker-failures", 0, "Consecutive failures after which a target isn't probed until the cooldown passes. 0 disables the circuit breaker")
+	breakerCooldown  = flag.Duration("circuit-breaker-cooldown", time.Minute, "How long to skip probes of a target once its circuit opens")
+	quietFailures    = flag.Bool("quiet-failures", false, "Log only the first of consecutive failed visits of a target, then a summary every -quiet-failures-interval and the recovery. Ignored with -debug")
+	quietInterval    = flag.Duration("quiet-failures-interval", 5*time.Minute, "Interval between summaries of consecutive failed visits of a target with -quiet-failures")
+	bodyMatchDir     = flag.String("body-match-dir", "", "Directory the body_match_file param is resolved in. body_match_file is rejected if empty")
+	tokenDir         = flag.String("token-dir", "", "Directory the token_file param is resolved in. token_file is rejected if empty")
+	maxBodyBytes     = flag.Int64("max-body-bytes", 10<<20, "Maximum response body size to read, after decompression(bytes)")
+	bodyHashMaxBytes = flag.Int64("body-hash-max-bytes", 10<<20, "Maximum response body size to hash for probe_body_sha256(bytes). 0 disables hashing")
+)
+
+// samplePercentiles are reported over the samples of a probe.
+var samplePercentiles = percentilesFlag{95}
+
+// sampleBuckets turn the samples of a probe into histograms if set.
+var sampleBuckets bucketsFlag
+
+// phaseSLOs are the budgets of the phases reported on probe_phase_ok.
+var phaseSLOs = phaseSLOFlag{}
+
+func init() {
+	flag.Var(&samplePercentiles, "sample-percentiles", "Comma separated percentiles of each phase reported when the samples param is set, e.g. 50,95,99.9")
+	flag.Var(&sampleBuckets, "sample-buckets", "Comma separated ascending bucket bounds(ms). If set, the samples of each phase are reported as a <phase>_samples histogram instead of statistics, e.g. 10,50,100,500,1000")
+	flag.Var(&phaseSLOs, "phase-slo", "Comma separated budgets of the phases dns, tcp, tls, server, transfer, ttfb and total reported on probe_phase_ok, e.g. dns=50ms,tls=200ms,server=500ms")
+}
+
+// metricsHandler serves the exporter's own metrics. Requests with a target
+// are still probed, since /metrics used to be the probe endpoint.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("target") != "" {
+		prometheusReqsHandler(w, r)
+		return
+	}
+	selfHandler.ServeHTTP(w, r)
+}
+
+// selfRegistry holds the exporter's own metrics, including the Go runtime
+// and process metrics that expose leaked goroutines of hung probes. Probe
+// results never go here.
+var selfRegistry = prometheus.NewRegistry()
+
+// selfHandler serves selfRegistry. It is set up in main, once the flags are
+// parsed.
+var selfHandler http.Handler
+
+// newMetricsHandler returns a handler serving the metrics of g in the format
+// chosen by the flags.
+func newMetricsHandler(g prometheus.Gatherer) http.Handler {
+	h := promhttp.HandlerFor(g, promhttp.HandlerOpts{EnableOpenMetrics: *openMetrics})
+	if !*openMetrics {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Negotiation picks OpenMetrics whatever the scraper accepts
+		r = r.Clone(r.Context())
+		r.Header.Set("Accept", "application/openmetrics-text")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// inflightScrapes counts the probe scrapes being handled, which pile up
+// when targets are slow.
+var inflightScrapes = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "httpmon_inflight_scrapes",
+	Help: "Number of probe scrapes currently being handled",
+})
+
+// scrapeTimeout derives the probe timeout(sec) from the scrape timeout
+// Prometheus sends, leaving headroom for the exporter to respond. ok is false
+// if header is missing or malformed.
+func scrapeTimeout(header string, headroom time.Duration) (timeout int, ok bool) {
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		return 0, false
+	}
+	timeout = int((time.Duration(seconds*float64(time.Second)) - headroom) / time.Second)
+	if timeout < 1 {
+		// Better to time out the scrape than to give the probe no time at all
+		timeout = 1
+	}
+	return timeout, true
+}
+
+// byteRangePattern matches the byte ranges of a Range header, without the
+// bytes= prefix.
+var byteRangePattern = regexp.MustCompile(`^(\d+-\d*|-\d+)(,(\d+-\d*|-\d+))*$`)
+
+// breaker is shared by all probes. nil disables it.
+var breaker *circuitBreaker
+
+// failureLogs is shared by all probes. nil logs every failed visit.
+var failureLogs *failureLog
+
+// newProbeCollector returns a collector for targetURL configured by the
+// command line flags.
+func newProbeCollector(targetURL string, timeout int, constLabels prometheus.Labels) *httpStatsCollector {
+	if *region != "" {
+		constLabels["region"] = *region
+	}
+
+	var phaseLabels []string
+	switch *statusLabelMode {
+	case "exact":
+		phaseLabels = []string{"code"}
+	case "both":
+		phaseLabels = []string{"status_code", "code"}
+	default:
+		phaseLabels = []string{"status_code"}
+	}
+	if *resultLabel {
+		phaseLabels = append(phaseLabels, "result")
+	}
+
+	c := newHTTPStatsCollector(targetURL, timeout, phaseLabels, constLabels)
+	c.noTLS = *noTLS
+	c.debug = *debug
+	c.maxBodyBytes = *maxBodyBytes
+	c.bodyHashMaxBytes = *bodyHashMaxBytes
+	if *maxRequests > 0 {
+		// Per scrape rather than per collector, see newPathsCollector
+		c.budget = newRequestBudget(*maxRequests)
+	}
+	c.tcpSlowThreshold = time.Duration(*tcpSlowMs) * time.Millisecond
+	c.maxResponseHeaderBytes = *maxHeaderBytes
+	c.nagle = !*tcpNoDelay
+	c.requestID = *requestID
+	c.samplePercentiles = samplePercentiles
+	c.sampleBuckets = sampleBuckets
+	c.phaseSLOs = phaseSLOs
+	c.breaker = breaker
+	if !c.debug {
+		c.failureLog = failureLogs
+	}
+	if *tlsSessionCache {
+		c.tlsSessionCache = sharedTLSSessionCache
+	}
+	return c
+}
+
+// prometheusReqsHandler probes the target given in the query, like
+// blackbox_exporter's /probe. The module param selects a module of
+// -config.file, which the other params override.
 func prometheusReqsHandler(w http.ResponseWriter, r *http.Request) {
+	inflightScrapes.Inc()
+	defer inflightScrapes.Dec()
+
 	params := r.URL.Query()
 	targetURL := params.Get("target")
 	if targetURL == "" {
 		http.Error(w, "Target param is missing", http.StatusBadRequest)
 		return
 	}
+	target, err := url.Parse(targetURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https" && target.Scheme != "tcp") {
+		http.Error(w, "Target param must be an http, https or tcp URL", http.StatusBadRequest)
+		return
+	}
+	if target.Scheme == "tcp" && (target.Port() == "" || target.Hostname() == "") {
+		http.Error(w, "tcp targets must be like tcp://host:port", http.StatusBadRequest)
+		return
+	}
+	if *noTLS && target.Scheme == "https" {
+		http.Error(w, "https targets are rejected because TLS is disabled by -no-tls", http.StatusBadRequest)
+		return
+	}
+
+	var m *module
+	if params.Get("module") != "" {
+		var ok bool
+		if m, ok = modules[params.Get("module")]; !ok {
+			http.Error(w, fmt.Sprintf("Unknown module %q", params.Get("module")), http.StatusBadRequest)
+			return
+		}
+	}
 
 	timeout := 10 // default timeout(sec)
-		if params.Get("timeout") != "" {
-		timeout, err := strconv.Atoi(params.Get("timeout"))
-		if err != nil {
+	scrapeTimeoutSet := false
+	if params.Get("timeout") != "" {
+		t, err := strconv.Atoi(params.Get("timeout"))
+		if err != nil || t <= 0 {
 			log.Printf("Invalid timeout parameter. Use default timeout: %d", timeout)
+		} else {
+			timeout = t
 		}
+	} else if t, ok := scrapeTimeout(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), *timeoutHeadroom); ok {
+		timeout = t
+		scrapeTimeoutSet = true
 	}
 
-	collector := newHTTPStatsCollector(targetURL, timeout)
+	warmup := false
+	if params.Get("warmup") != "" {
+		var err error
+		warmup, err = strconv.ParseBool(params.Get("warmup"))
+		if err != nil {
+			http.Error(w, "Invalid warmup param", http.StatusBadRequest)
+			return
+		}
+	}
+
+	constLabels := prometheus.Labels{}
+	if params.Get("path_label") != "" {
+		name, value, err := pathLabel(params.Get("path_label"), targetURL)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid path_label param: %s", err), http.StatusBadRequest)
+			return
+		}
+		constLabels[name] = value
+	}
+
+	collector := newProbeCollector(targetURL, timeout, constLabels)
+	if m != nil {
+		m.apply(collector)
+		// The timeout param overrides the module, and the scrape timeout caps it
+		if params.Get("timeout") != "" || (scrapeTimeoutSet && time.Duration(timeout)*time.Second < collector.totalTimeout) {
+			collector.totalTimeout = 0
+		}
+	}
+	if params.Get("warmup") != "" {
+		collector.warmup = warmup
+	}
+	collector.host = params.Get("host")
+
+	if params.Get("method") != "" {
+		method := strings.ToUpper(params.Get("method"))
+		if !probeMethods[method] {
+			http.Error(w, fmt.Sprintf("Unsupported method param %q", params.Get("method")), http.StatusBadRequest)
+			return
+		}
+		collector.method = method
+	}
+	// Each header param is "Name: value", replacing the module's header of the name
+	paramHeaders := http.Header{}
+	for _, header := range params["header"] {
+		kv := strings.SplitN(header, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			http.Error(w, fmt.Sprintf("Invalid header param %q, must be Name: value", header), http.StatusBadRequest)
+			return
+		}
+		paramHeaders.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	if len(paramHeaders) > 0 && collector.headers == nil {
+		collector.headers = http.Header{}
+	}
+	for name, values := range paramHeaders {
+		collector.headers[name] = values
+	}
+	if params.Get("body") != "" {
+		collector.body = []byte(params.Get("body"))
+	}
+
+	if params.Get("token_file") != "" {
+		tokenFile, err := resolveTokenFile(*tokenDir, params.Get("token_file"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid token_file param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.tokenFile = tokenFile
+	}
+
+	if params.Get("body_match_file") != "" {
+		bodyMatchFile, err := resolveBodyMatchFile(*bodyMatchDir, params.Get("body_match_file"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid body_match_file param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.bodyMatchFile = bodyMatchFile
+	}
+
+	for _, expr := range params["json_assert"] {
+		a, err := parseJSONAssertion(expr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid json_assert param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.jsonAssertions = append(collector.jsonAssertions, a)
+	}
+	for _, expr := range params["fail_if_header_not_matches"] {
+		m, err := parseHeaderMatch(expr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid fail_if_header_not_matches param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.headerMatches = append(collector.headerMatches, m)
+	}
+	for _, expr := range params["fail_if_header_matches"] {
+		m, err := parseHeaderMatch(expr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid fail_if_header_matches param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.headerForbidden = append(collector.headerForbidden, m)
+	}
+	for _, expr := range params["fail_if_body_not_matches_regexp"] {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid fail_if_body_not_matches_regexp param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.bodyMatches = append(collector.bodyMatches, re)
+	}
+	for _, expr := range params["fail_if_body_matches_regexp"] {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid fail_if_body_matches_regexp param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.bodyForbidden = append(collector.bodyForbidden, re)
+	}
+
+	if params.Get("head_for_size") != "" {
+		headForSize, err := strconv.ParseBool(params.Get("head_for_size"))
+		if err != nil {
+			http.Error(w, "Invalid head_for_size param", http.StatusBadRequest)
+			return
+		}
+		if headForSize {
+			if params.Get("method") != "" && collector.method != "HEAD" {
+				http.Error(w, "head_for_size param can't be used with the method param", http.StatusBadRequest)
+				return
+			}
+			collector.method = "HEAD"
+			collector.headForSize = true
+		}
+	}
+
+	if len(collector.body) > 0 && collector.method == "HEAD" {
+		http.Error(w, "body can't be sent with HEAD", http.StatusBadRequest)
+		return
+	}
+
+	if params.Get("expect_location") != "" {
+		// Either the exact location or a regex matching all of it
+		expectLocation, err := regexp.Compile("^(?:" + params.Get("expect_location") + ")$")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid expect_location param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.expectLocation = expectLocation
+	}
+
+	collector.ifNoneMatch = params.Get("if_none_match")
+	if params.Get("if_modified_since") != "" {
+		if _, err := http.ParseTime(params.Get("if_modified_since")); err != nil {
+			http.Error(w, "Invalid if_modified_since param, must be an HTTP date", http.StatusBadRequest)
+			return
+		}
+		collector.ifModifiedSince = params.Get("if_modified_since")
+	}
+
+	if params.Get("range") != "" {
+		if !byteRangePattern.MatchString(params.Get("range")) {
+			http.Error(w, "Invalid range param, must be like 0-1023", http.StatusBadRequest)
+			return
+		}
+		collector.byteRange = params.Get("range")
+	}
+
+	if params.Get("samples") != "" {
+		samples, err := strconv.Atoi(params.Get("samples"))
+		if err != nil || samples < 1 || samples > maxSamples {
+			http.Error(w, fmt.Sprintf("Invalid samples param, must be 1 to %d", maxSamples), http.StatusBadRequest)
+			return
+		}
+		collector.samples = samples
+	}
+
+	switch params.Get("http_version") {
+	case "":
+	case "1.1":
+		collector.http11 = true
+	case "1.0":
+		if target.Scheme != "http" {
+			http.Error(w, "http_version=1.0 requires an http target", http.StatusBadRequest)
+			return
+		}
+		collector.http10 = true
+	default:
+		http.Error(w, "Invalid http_version param, must be 1.0 or 1.1", http.StatusBadRequest)
+		return
+	}
+
+	if params.Get("same_host_only") != "" {
+		sameHostOnly, err := strconv.ParseBool(params.Get("same_host_only"))
+		if err != nil {
+			http.Error(w, "Invalid same_host_only param", http.StatusBadRequest)
+			return
+		}
+		collector.sameHostOnly = sameHostOnly
+	}
+
+	if params.Get("max_redirects") != "" {
+		maxRedirects, err := strconv.Atoi(params.Get("max_redirects"))
+		if err != nil || maxRedirects < 0 || maxRedirects > maxRedirectsLimit {
+			http.Error(w, fmt.Sprintf("Invalid max_redirects param, must be 0 to %d", maxRedirectsLimit), http.StatusBadRequest)
+			return
+		}
+		collector.maxRedirects = maxRedirects
+	}
+	if params.Get("follow_redirects") != "" {
+		followRedirects, err := strconv.ParseBool(params.Get("follow_redirects"))
+		if err != nil {
+			http.Error(w, "Invalid follow_redirects param", http.StatusBadRequest)
+			return
+		}
+		if !followRedirects {
+			if collector.maxRedirects > 0 && params.Get("max_redirects") != "" {
+				http.Error(w, "follow_redirects=false can't be used with max_redirects", http.StatusBadRequest)
+				return
+			}
+			collector.maxRedirects = 0
+		}
+	}
+
+	if params.Get("expect_body") != "" {
+		expectBody, err := strconv.ParseBool(params.Get("expect_body"))
+		if err != nil {
+			http.Error(w, "Invalid expect_body param", http.StatusBadRequest)
+			return
+		}
+		collector.expectBody = expectBody
+	}
+
+	if params.Get("min_body_bytes") != "" {
+		minBodyBytes, err := strconv.ParseInt(params.Get("min_body_bytes"), 10, 64)
+		if err != nil || minBodyBytes < 0 {
+			http.Error(w, "Invalid min_body_bytes param", http.StatusBadRequest)
+			return
+		}
+		collector.minBodyBytes = minBodyBytes
+	}
+
+	if params.Get("accept_encoding") != "" {
+		acceptEncoding, err := parseAcceptEncoding(params.Get("accept_encoding"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid accept_encoding param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.acceptEncoding = acceptEncoding
+	}
+
+	if params.Get("expected_sha256") != "" {
+		sum, err := parseSHA256(params.Get("expected_sha256"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid expected_sha256 param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.expectedSHA256 = sum
+	}
+
+	if params.Get("head_only_timing") != "" {
+		headOnlyTiming, err := strconv.ParseBool(params.Get("head_only_timing"))
+		if err != nil {
+			http.Error(w, "Invalid head_only_timing param", http.StatusBadRequest)
+			return
+		}
+		if headOnlyTiming && (collector.keepBody() || collector.minBodyBytes > 0 || collector.expectedSHA256 != "") {
+			http.Error(w, "head_only_timing can't be combined with checks of the body", http.StatusBadRequest)
+			return
+		}
+		collector.headOnlyTiming = headOnlyTiming
+	}
+
+	if params.Get("retries") != "" {
+		retries, err := strconv.Atoi(params.Get("retries"))
+		if err != nil || retries < 0 || retries > maxRetries {
+			http.Error(w, fmt.Sprintf("Invalid retries param, must be 0 to %d", maxRetries), http.StatusBadRequest)
+			return
+		}
+		collector.retries = retries
+	}
+	collector.retryOn = defaultRetryOn
+	if params.Get("retry_on") != "" {
+		retryOn, err := parseRetryOn(params.Get("retry_on"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid retry_on param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.retryOn = retryOn
+	}
+
+	if params.Get("tls_only") != "" {
+		tlsOnly, err := strconv.ParseBool(params.Get("tls_only"))
+		if err != nil {
+			http.Error(w, "Invalid tls_only param", http.StatusBadRequest)
+			return
+		}
+		if tlsOnly && target.Scheme != "https" {
+			http.Error(w, "tls_only requires an https target", http.StatusBadRequest)
+			return
+		}
+		collector.tlsOnly = tlsOnly
+	}
+
+	if params.Get("websocket_echo") != "" {
+		wsEcho, err := strconv.ParseBool(params.Get("websocket_echo"))
+		if err != nil {
+			http.Error(w, "Invalid websocket_echo param", http.StatusBadRequest)
+			return
+		}
+		if wsEcho && collector.tlsOnly {
+			http.Error(w, "websocket_echo can't be combined with tls_only", http.StatusBadRequest)
+			return
+		}
+		collector.wsEcho = wsEcho
+	}
+
+	if params.Get("grpc") != "" {
+		grpc, err := strconv.ParseBool(params.Get("grpc"))
+		if err != nil {
+			http.Error(w, "Invalid grpc param", http.StatusBadRequest)
+			return
+		}
+		if grpc && (collector.tlsOnly || collector.wsEcho) {
+			http.Error(w, "grpc can't be combined with tls_only or websocket_echo", http.StatusBadRequest)
+			return
+		}
+		collector.grpc = grpc
+		collector.grpcService = params.Get("grpc_service")
+	}
+
+	if params.Get("tcp_tls") != "" {
+		tcpTLS, err := strconv.ParseBool(params.Get("tcp_tls"))
+		if err != nil {
+			http.Error(w, "Invalid tcp_tls param", http.StatusBadRequest)
+			return
+		}
+		if tcpTLS && target.Scheme != "tcp" {
+			http.Error(w, "tcp_tls requires a tcp target", http.StatusBadRequest)
+			return
+		}
+		collector.tcpTLS = tcpTLS
+	}
+	if collector.tcp && collector.tcpTLS && *noTLS {
+		http.Error(w, "tcp_tls is rejected because TLS is disabled by -no-tls", http.StatusBadRequest)
+		return
+	}
+	if collector.tcp && (collector.wsEcho || collector.grpc) {
+		http.Error(w, "tcp targets can't be combined with websocket_echo or grpc", http.StatusBadRequest)
+		return
+	}
+
+	if params.Get("socks5") != "" {
+		socks5, err := parseSOCKS5(params.Get("socks5"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid socks5 param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.socks5 = socks5
+	}
+
+	if params.Get("proxy_protocol") != "" {
+		if collector.socks5 != nil {
+			http.Error(w, "proxy_protocol can't be combined with socks5", http.StatusBadRequest)
+			return
+		}
+		proxyProtocol, err := parseProxyProtocol(params.Get("proxy_protocol"), params.Get("proxy_protocol_src"), params.Get("proxy_protocol_dst"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid proxy_protocol param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.proxyProtocol = proxyProtocol
+	}
+
+	if params.Get("insecure_skip_verify") != "" {
+		insecureSkipVerify, err := strconv.ParseBool(params.Get("insecure_skip_verify"))
+		if err != nil {
+			http.Error(w, "Invalid insecure_skip_verify param", http.StatusBadRequest)
+			return
+		}
+		collector.insecureSkipVerify = insecureSkipVerify
+	}
+
+	if params.Get("http3") != "" {
+		http3, err := strconv.ParseBool(params.Get("http3"))
+		if err != nil {
+			http.Error(w, "Invalid http3 param", http.StatusBadRequest)
+			return
+		}
+		collector.http3 = http3
+	}
+	if collector.http3 {
+		if target.Scheme != "https" {
+			http.Error(w, "http3 requires an https target", http.StatusBadRequest)
+			return
+		}
+		if collector.tlsOnly || collector.wsEcho || collector.grpc || collector.socks5 != nil || collector.proxyProtocol != nil || params.Get("http_version") != "" {
+			http.Error(w, "http3 can't be combined with tls_only, websocket_echo, grpc, socks5, proxy_protocol or http_version", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if params.Get("resolve") != "" {
+		resolve, err := parseResolve(params.Get("resolve"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid resolve param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.resolve = resolve
+	}
+
+	if params.Get("source_ip") != "" {
+		sourceIP := net.ParseIP(params.Get("source_ip"))
+		if sourceIP == nil {
+			http.Error(w, "Invalid source_ip param", http.StatusBadRequest)
+			return
+		}
+		collector.sourceIP = sourceIP
+	}
+
+	if params.Get("dns_record_type") != "" {
+		ipNetwork, ok := dnsRecordNetworks[strings.ToUpper(params.Get("dns_record_type"))]
+		if !ok {
+			http.Error(w, "Invalid dns_record_type param, must be A or AAAA", http.StatusBadRequest)
+			return
+		}
+		if collector.socks5 != nil {
+			http.Error(w, "dns_record_type can't be used with socks5, which resolves the target remotely", http.StatusBadRequest)
+			return
+		}
+		collector.ipNetwork = ipNetwork
+	}
+
+	if params.Get("preferred_ip_protocol") != "" {
+		preferredIP := strings.ToLower(params.Get("preferred_ip_protocol"))
+		if preferredIP != "ip4" && preferredIP != "ip6" {
+			http.Error(w, "Invalid preferred_ip_protocol param, must be ip4 or ip6", http.StatusBadRequest)
+			return
+		}
+		collector.preferredIP = preferredIP
+	}
+	if params.Get("ip_protocol_fallback") != "" {
+		ipFallback, err := strconv.ParseBool(params.Get("ip_protocol_fallback"))
+		if err != nil {
+			http.Error(w, "Invalid ip_protocol_fallback param", http.StatusBadRequest)
+			return
+		}
+		collector.ipFallback = ipFallback
+	}
+	if collector.preferredIP != "" && (collector.socks5 != nil || collector.ipNetwork != "") {
+		http.Error(w, "preferred_ip_protocol can't be used with socks5 or dns_record_type", http.StatusBadRequest)
+		return
+	}
+
+	if params.Get("dns_server") != "" {
+		server, err := parseDNSServer(params.Get("dns_server"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid dns_server param: %s", err), http.StatusBadRequest)
+			return
+		}
+		collector.dnsServer = server
+	}
+	if collector.dnsServer != nil && collector.socks5 != nil {
+		http.Error(w, "dns_server can't be used with socks5, which resolves the target remotely", http.StatusBadRequest)
+		return
+	}
+
+	if params.Get("dns_timeout") != "" {
+		dnsTimeout, err := time.ParseDuration(params.Get("dns_timeout"))
+		if err != nil || dnsTimeout <= 0 {
+			http.Error(w, "Invalid dns_timeout param", http.StatusBadRequest)
+			return
+		}
+		collector.dnsTimeout = dnsTimeout
+	}
+
+	if params.Get("timeouts") != "" {
+		timeouts, err := parseTimeouts(params.Get("timeouts"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid timeouts param: %s", err), http.StatusBadRequest)
+			return
+		}
+		// Phases left out keep the timeouts of the module
+		if timeouts.dns > 0 {
+			collector.dnsTimeout = timeouts.dns
+		}
+		if timeouts.connect > 0 {
+			collector.connectTimeout = timeouts.connect
+		}
+		if timeouts.tls > 0 {
+			collector.tlsTimeout = timeouts.tls
+		}
+		if timeouts.total > 0 {
+			collector.totalTimeout = timeouts.total
+		}
+	}
+
+	var probe prometheus.Collector = collector
+	if paths := params["paths"]; len(paths) > 0 {
+		pc, err := newPathsCollector(collector, target, paths, *pathsConcurrency)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid paths param: %s", err), http.StatusBadRequest)
+			return
+		}
+		probe = pc
+	}
 
 	registry := prometheus.NewRegistry()
-	registry.MustRegister(collector)
+	if err := registry.Register(probe); err != nil {
+		http.Error(w, fmt.Sprintf("Collector registration error: %s", err), http.StatusInternalServerError)
+		return
+	}
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
-	h.ServeHTTP(w, r)
+	newMetricsHandler(registry).ServeHTTP(w, r)
 }
 
-
 func main() {
 	var (
-		addr = flag.String("a", "127.0.0.1:8888", "Listen address")
+		addr       = flag.String("a", "127.0.0.1:8888", "Listen address")
+		configFile = flag.String("config.file", "", "YAML file defining the probe modules selected with the module param")
+
+		targetsFile   = flag.String("targets-file", "", "File listing target URLs, one per line, to probe on a schedule, optionally followed by interval=<duration> and module=<name>. Results are served on /metrics. Reloaded on SIGHUP")
+		probeInterval = flag.Duration("probe-interval", 30*time.Second, "Default interval between probes of the targets in -targets-file")
+		statsdAddr    = flag.String("statsd-addr", "", "StatsD address to also send the results of the probes of -targets-file to")
+		statsdPrefix  = flag.String("statsd-prefix", "http_exporter", "Prefix of the StatsD metric names")
+		keepAlive     = flag.Bool("keep-alive", false, "Reuse connections across the scheduled probes of each target")
+		prewarm       = flag.Bool("prewarm", false, "Open a connection to each scheduled target at startup so that the first probe isn't cold. Requires -keep-alive")
+
+		slackWebhookURL = flag.String("slack-webhook-url", "", "Slack incoming webhook URL for alerts. Alerts are disabled if empty")
+		slackChannel    = flag.String("slack-channel", "", "Slack channel to post alerts to")
+		slackUsername   = flag.String("slack-username", "http_exporter", "Slack username to post alerts as")
+		ttfbWarnMs      = flag.Int("ttfb-warn-ms", 500, "TTFB at which Slack alerts turn warning(ms). 0 disables")
+		ttfbCritMs      = flag.Int("ttfb-crit-ms", 2000, "TTFB at which Slack alerts turn danger(ms). 0 disables")
+
+		startupCheckURL = flag.String("startup-check-url", "", "URL probed at startup before /ready reports ready. Empty means ready at once")
+		startupTimeout  = flag.Duration("startup-check-timeout", time.Minute, "How long the startup check retries before /ready reports ready anyway")
 	)
 	flag.Parse()
 
-	http.HandleFunc("/metrics", prometheusReqsHandler)
+	switch *statusLabelMode {
+	case "bucket", "exact", "both":
+	default:
+		log.Fatalf("Invalid -status-label-mode %q, must be bucket, exact or both", *statusLabelMode)
+	}
+
+	selfHandler = promhttp.InstrumentMetricHandler(selfRegistry, newMetricsHandler(selfRegistry))
+	selfRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		inflightScrapes,
+	)
+
+	if *configFile != "" {
+		var err error
+		if modules, err = loadModules(*configFile); err != nil {
+			log.Fatalf("Config file error: %s", err)
+		}
+	}
+	if *breakerFailures > 0 {
+		breaker = newCircuitBreaker(*breakerFailures, *breakerCooldown)
+	}
+	if *quietFailures {
+		failureLogs = newFailureLog(*quietInterval)
+	}
+
+	if *slackWebhookURL != "" {
+		sc, err := slack.NewSlack(*slackWebhookURL, *slackChannel, *slackUsername)
+		if err != nil {
+			log.Fatalf("Slack client error: %s", err)
+		}
+		sc.TTFBWarn = time.Duration(*ttfbWarnMs) * time.Millisecond
+		sc.TTFBCrit = time.Duration(*ttfbCritMs) * time.Millisecond
+		slackClient = sc
+	}
+
+	if *targetsFile != "" {
+		if *prewarm && !*keepAlive {
+			log.Fatal("-prewarm requires -keep-alive")
+		}
+		ts := newTargetScheduler(*targetsFile, *probeInterval)
+		ts.keepAlive = *keepAlive
+		if *statsdAddr != "" {
+			sc, err := newStatsdClient(*statsdAddr, *statsdPrefix)
+			if err != nil {
+				log.Fatalf("StatsD client error: %s", err)
+			}
+			ts.statsd = sc
+		}
+		ts.prewarm = *prewarm
+		if err := ts.load(); err != nil {
+			log.Fatalf("Targets file error: %s", err)
+		}
+		selfRegistry.MustRegister(ts)
+		go ts.run()
+	}
+
+	http.HandleFunc("/", rootHandler)
+	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("/probe", prometheusReqsHandler)
+	http.HandleFunc("/ready", readyHandler)
+	http.HandleFunc("/silence", silenceHandler)
+
+	if *startupCheckURL != "" {
+		go startupCheck(*startupCheckURL, *startupTimeout)
+	} else {
+		atomic.StoreInt32(&ready, 1)
+	}
 
 	log.Printf("Listening on addr %s\n", *addr)
 	err := http.ListenAndServe(*addr, nil)
 	if err != nil {
 		log.Fatalf("Server Listening error: %s", err)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// jsonAssertion compares the value at a dotted path of a JSON body with a
+// constant, e.g. `$.queue.depth < 100` or `$.status == "ok"`. Array elements
+// are addressed by index, e.g. `$.shards.0.lag`. Strings and booleans can
+// only be compared with == and !=.
+type jsonAssertion struct {
+	expr     string
+	jsonPath string // e.g. $.queue.depth
+	path     []string
+	op       string
+	value    interface{} // float64, string or bool, as decoded by encoding/json
+}
+
+var jsonAssertionPattern = regexp.MustCompile(`^\$((?:\.[^.\s<>=!]+)*)\s*(<=|>=|==|!=|<|>)\s*(\S.*)$`)
+
+func parseJSONAssertion(s string) (*jsonAssertion, error) {
+	s = strings.TrimSpace(s)
+	m := jsonAssertionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("%q is not like $.path < 100", s)
+	}
+	a := &jsonAssertion{expr: s, jsonPath: "$" + m[1], op: m[2]}
+	switch {
+	case strings.HasPrefix(m[3], `"`):
+		var str string
+		if err := json.Unmarshal([]byte(m[3]), &str); err != nil {
+			return nil, fmt.Errorf("%s is not a JSON string", m[3])
+		}
+		a.value = str
+	case m[3] == "true" || m[3] == "false":
+		a.value = m[3] == "true"
+	default:
+		n, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number, string or boolean", m[3])
+		}
+		a.value = n
+	}
+	if _, ok := a.value.(float64); !ok && a.op != "==" && a.op != "!=" {
+		return nil, fmt.Errorf("%s can't be compared with %s", m[3], a.op)
+	}
+	if m[1] != "" {
+		a.path = strings.Split(m[1][1:], ".")
+	}
+	return a, nil
+}
+
+// lookup returns the value at the path of a in doc, a JSON document decoded
+// into interface{}.
+func (a *jsonAssertion) lookup(doc interface{}) (interface{}, error) {
+	v := doc
+	for _, key := range a.path {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return nil, fmt.Errorf("%s: no %q", a.expr, key)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("%s: no index %q", a.expr, key)
+			}
+			v = node[i]
+		default:
+			return nil, fmt.Errorf("%s: can't look up %q in a scalar", a.expr, key)
+		}
+	}
+	return v, nil
+}
+
+// eval evaluates a against v, the value at its path.
+func (a *jsonAssertion) eval(v interface{}) (bool, error) {
+	want, ok := a.value.(float64)
+	if !ok {
+		// A value of another type is simply not equal
+		equal := v == a.value
+		return equal == (a.op == "=="), nil
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return false, fmt.Errorf("%s: %v is not a number", a.expr, v)
+	}
+	switch a.op {
+	case "<":
+		return n < want, nil
+	case ">":
+		return n > want, nil
+	case "<=":
+		return n <= want, nil
+	case ">=":
+		return n >= want, nil
+	case "!=":
+		return n != want, nil
+	}
+	return n == want, nil
+}
+
+// jsonAssertionResult is the outcome of a jsonAssertion.
+type jsonAssertionResult struct {
+	ok      bool
+	value   float64 // the number at the path, if numeric
+	numeric bool
+	err     error // e.g. a missing path, which counts as a failure
+}
+
+// evalJSONAssertions parses body once and evaluates each assertion against
+// it. It fails only if body isn't JSON.
+func evalJSONAssertions(body []byte, assertions []*jsonAssertion) ([]jsonAssertionResult, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	results := make([]jsonAssertionResult, len(assertions))
+	for i, a := range assertions {
+		r := &results[i]
+		v, err := a.lookup(doc)
+		if err != nil {
+			r.err = err
+			continue
+		}
+		switch v := v.(type) {
+		case float64:
+			r.value, r.numeric = v, true
+		case bool:
+			// Flags such as $.healthy graph well too
+			r.value, r.numeric = bool2float(v), true
+		}
+		r.ok, r.err = a.eval(v)
+	}
+	return results, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestJSONAssertion(t *testing.T) {
+	body := []byte(`{"queue_depth": 42, "shards": [{"lag": 0.5}, {"lag": 3}], "status": "ok", "healthy": true}`)
+	tests := []struct {
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{"$.queue_depth < 100", true, false},
+		{"$.queue_depth>=42", true, false},
+		{"$.queue_depth > 42", false, false},
+		{"$.queue_depth == 42", true, false},
+		{"$.shards.1.lag <= 2.5", false, false},
+		{"$.shards.0.lag <= 2.5", true, false},
+		{"$.shards.2.lag < 1", false, true},
+		{"$.missing < 1", false, true},
+		{"$.status < 1", false, true},
+		{"$.queue_depth != 41", true, false},
+		{`$.status == "ok"`, true, false},
+		{`$.status != "ok"`, false, false},
+		{`$.status == "o k"`, false, false},
+		{`$.queue_depth == "42"`, false, false},
+		{"$.healthy == true", true, false},
+	}
+	for _, tt := range tests {
+		a, err := parseJSONAssertion(tt.expr)
+		if err != nil {
+			t.Errorf("parseJSONAssertion(%q): %s", tt.expr, err)
+			continue
+		}
+		results, err := evalJSONAssertions(body, []*jsonAssertion{a})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := results[0]; r.ok != tt.want || (r.err != nil) != tt.wantErr {
+			t.Errorf("%s = %v, %v, want %v, wantErr %v", tt.expr, r.ok, r.err, tt.want, tt.wantErr)
+		}
+	}
+
+	for _, bad := range []string{"queue_depth < 1", "$.queue_depth ~ 1", "$.queue_depth < x", "$.a..b < 1", `$.status < "ok"`, `$.status == "ok`, "$.healthy > true"} {
+		if _, err := parseJSONAssertion(bad); err == nil {
+			t.Errorf("parseJSONAssertion(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestProbeHandlerJSONAssert(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"queue_depth": 120, "status": "ok"}`)
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		exprs []string
+		want  []string
+	}{
+		{[]string{"$.queue_depth < 200"}, []string{"probe_json_assertion 1", `probe_json_value{json_path="$.queue_depth"} 120`}},
+		{[]string{"$.queue_depth < 100"}, []string{`probe_failure_reason{reason="json_assertion"} 1`}},
+		{[]string{`$.status == "ok"`, "$.queue_depth > 100", "$.queue_depth < 110"}, []string{
+			"probe_json_assertion 0",
+			`probe_json_assertion_result{expression="$.status == \"ok\""} 1`,
+			`probe_json_assertion_result{expression="$.queue_depth > 100"} 1`,
+			`probe_json_assertion_result{expression="$.queue_depth < 110"} 0`,
+		}},
+	} {
+		q := url.Values{"target": {ts.URL}, "json_assert": tt.exprs}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		for _, want := range tt.want {
+			if body := rec.Body.String(); !strings.Contains(body, want) {
+				t.Errorf("json_assert=%q: %q not found in:\n%s", tt.exprs, want, body)
+			}
+		}
+	}
+}
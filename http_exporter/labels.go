@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/prometheus/common/model"
+)
+
+// maxPathLabelLength bounds path label values so that a broad pattern
+// can't smuggle whole paths into the label.
+const maxPathLabelLength = 64
+
+// reservedLabels are used by the collector itself.
+var reservedLabels = map[string]bool{
+	"region":              true,
+	"status_code":         true,
+	"reason":              true,
+	"sha256":              true,
+	"protocol":            true,
+	"tls_version":         true,
+	"cipher":              true,
+	"source_ip":           true,
+	"target":              true,
+	"size_method":         true,
+	"result":              true,
+	"request_id":          true,
+	"http_version":        true,
+	"phase":               true,
+	"signature_algorithm": true,
+	"key_type":            true,
+	"serving_status":      true,
+	"subject":             true,
+	"issuer":              true,
+	"serial":              true,
+	"key_size":            true,
+	"hop":                 true,
+	"url":                 true,
+	"expression":          true,
+	"json_path":           true,
+	"path":                true, // of the paths param
+	"resumed":             true, // of tls_handshake_time
+	"host":                true, // of probe_redirect_off_host
+	"code":                true, // of -status-label-mode exact and both
+}
+
+// pathLabel extracts a label from the path of target using pattern, which
+// must have exactly one named capture group, e.g. `^/api/(?P<version>v\d+)/`.
+// The group name is the label name. An empty value is returned if the path
+// doesn't match.
+func pathLabel(pattern, target string) (name, value string, err error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, n := range re.SubexpNames()[1:] {
+		if n == "" {
+			continue
+		}
+		if name != "" {
+			return "", "", errors.New("more than one named capture group")
+		}
+		name = n
+	}
+	if name == "" {
+		return "", "", errors.New("no named capture group")
+	}
+	if !model.LabelName(name).IsValid() || reservedLabels[name] {
+		return "", "", fmt.Errorf("invalid label name %q", name)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", err
+	}
+	m := re.FindStringSubmatch(u.Path)
+	if m == nil {
+		return name, "", nil
+	}
+	value = m[re.SubexpIndex(name)]
+	if !utf8.ValidString(value) || len(value) > maxPathLabelLength {
+		return "", "", fmt.Errorf("invalid label value %q", value)
+	}
+	return name, value, nil
+}
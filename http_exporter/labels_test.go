@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestPathLabel(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		target    string
+		wantName  string
+		wantValue string
+		wantErr   bool
+	}{
+		{`^/api/(?P<version>v\d+)/`, "https://example.com/api/v2/users", "version", "v2", false},
+		{`^/api/(?P<version>v\d+)/`, "https://example.com/healthz", "version", "", false},
+		{`^/(?P<a>\w+)/(?P<b>\w+)`, "https://example.com/x/y", "", "", true},
+		{`^/api/(v\d+)/`, "https://example.com/api/v2/", "", "", true},
+		{`^/(?P<region>\w+)`, "https://example.com/x", "", "", true},
+		{`^/(?P<path>\w+)`, "https://example.com/x", "", "", true},
+		{`^/(?P<code>\w+)`, "https://example.com/x", "", "", true},
+		{`^/(?P<host>\w+)`, "https://example.com/x", "", "", true},
+		{`^/(?P<resumed>\w+)`, "https://example.com/x", "", "", true},
+		{`(`, "https://example.com/", "", "", true},
+	}
+	for _, tt := range tests {
+		name, value, err := pathLabel(tt.pattern, tt.target)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pathLabel(%q, %q) error = %v, wantErr %v", tt.pattern, tt.target, err, tt.wantErr)
+			continue
+		}
+		if name != tt.wantName || value != tt.wantValue {
+			t.Errorf("pathLabel(%q, %q) = %q, %q, want %q, %q", tt.pattern, tt.target, name, value, tt.wantName, tt.wantValue)
+		}
+	}
+}
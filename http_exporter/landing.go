@@ -0,0 +1,40 @@
+package main
+
+import "net/http"
+
+const landingPage = `<!DOCTYPE html>
+<html>
+<head><title>http_exporter</title></head>
+<body>
+<h1>http_exporter</h1>
+<ul>
+<li><a href="/metrics">/metrics</a>: metrics of the exporter itself</li>
+<li><a href="/ready">/ready</a>: readiness</li>
+<li><a href="/silence">/silence</a>: targets whose Slack alerts are silenced. POST with target and duration to add one, DELETE with target to lift it</li>
+<li><a href="/probe?target=https://www.example.com/">/probe?target=https://www.example.com/</a>: probes the target</li>
+</ul>
+<h2>Example probes</h2>
+<ul>
+<li><code>/probe?target=https://www.example.com/&amp;warmup=true</code>: measures on a warm connection</li>
+<li><code>/probe?target=https://www.example.com/&amp;samples=10</code>: reports percentiles over 10 samples</li>
+<li><code>/probe?target=https://api.example.com/ping&amp;method=POST&amp;header=Content-Type:%20application/json&amp;body=%7B%7D</code>: probes with POST, a header and a body</li>
+<li><code>/probe?target=https://www.example.com/healthz&amp;json_assert=$.queue_depth%20%3C%20100</code>: checks a JSON body</li>
+<li><code>/probe?target=https://www.example.com/&amp;fail_if_body_matches_regexp=(?i)maintenance</code>: fails if the body matches a regex, e.g. an error page served with 200</li>
+<li><code>/probe?target=https://www.example.com/&amp;resolve=www.example.com:443:192.0.2.1</code>: probes a specific server</li>
+<li><code>/probe?target=https://www.example.com/&amp;http3=true</code>: probes over HTTP/3</li>
+<li><code>/probe?target=tcp://db.example.com:5432</code>: only connects over TCP, <code>tcp_tls=true</code> to handshake TLS too</li>
+<li><code>/probe?target=http://grpc.example.com:50051&amp;grpc=true</code>: makes a gRPC health check, in plaintext for http targets</li>
+</ul>
+</body>
+</html>
+`
+
+// rootHandler serves a landing page for humans at / and 404s elsewhere.
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(landingPage))
+}
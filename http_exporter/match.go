@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// resolveBodyMatchFile resolves a body_match_file param within dir, which
+// confines it like resolveTokenFile.
+func resolveBodyMatchFile(dir, name string) (string, error) {
+	if dir == "" {
+		return "", errors.New("body match files are disabled, set -body-match-dir to enable them")
+	}
+	path, ok := confine(dir, name)
+	if !ok {
+		return "", errors.New("body match file must be within -body-match-dir")
+	}
+	return path, nil
+}
+
+// readBodyMatch reads a regex for the response body from path. A substring
+// without regex metacharacters matches itself. Surrounding whitespace, such
+// as the final newline, is ignored.
+func readBodyMatch(path string) (*regexp.Regexp, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pattern := strings.TrimSpace(string(b))
+	if pattern == "" {
+		return nil, errors.New("body match file " + path + " is empty")
+	}
+	return regexp.Compile(pattern)
+}
+
+// headerMatch is a regex on the values of a response header. An empty
+// regex matches any value, checking for the presence of the header.
+type headerMatch struct {
+	header       string
+	re           *regexp.Regexp
+	allowMissing bool // whether a missing header passes a check it must match
+}
+
+func newHeaderMatch(header, pattern string, allowMissing bool) (*headerMatch, error) {
+	if header == "" {
+		return nil, errors.New("no header")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &headerMatch{header: http.CanonicalHeaderKey(header), re: re, allowMissing: allowMissing}, nil
+}
+
+// parseHeaderMatch parses a param like the header param, `Name: regex`.
+func parseHeaderMatch(s string) (*headerMatch, error) {
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 {
+		return nil, fmt.Errorf("%q is not like Name: regex", s)
+	}
+	return newHeaderMatch(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]), false)
+}
+
+// matches reports whether any value of the header in h matches, and
+// whether there is one at all.
+func (m *headerMatch) matches(h http.Header) (matched, present bool) {
+	values := h.Values(m.header)
+	for _, v := range values {
+		if m.re.MatchString(v) {
+			return true, true
+		}
+	}
+	return false, len(values) > 0
+}
+
+// failedHeaderMatch returns the header of the first check h fails: each of
+// required must match unless missing and allowed to be, and none of
+// forbidden may match. It is empty if h passes.
+func failedHeaderMatch(h http.Header, required, forbidden []*headerMatch) string {
+	for _, m := range required {
+		matched, present := m.matches(h)
+		if !matched && (present || !m.allowMissing) {
+			return m.header
+		}
+	}
+	for _, m := range forbidden {
+		if matched, _ := m.matches(h); matched {
+			return m.header
+		}
+	}
+	return ""
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProbeHandlerBodyMatchFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bodymatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "ok"), []byte(`"status":\s*"ok"`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad"), []byte("(unclosed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	*bodyMatchDir = dir
+	defer func() { *bodyMatchDir = "" }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "` + r.URL.Query().Get("status") + `"}`))
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		status, file string
+		want         []string
+	}{
+		{"ok", "ok", []string{"probe_content_match 1", "probe_success 1"}},
+		{"down", "ok", []string{"probe_content_match 0", `probe_failure_reason{reason="body_match"} 1`}},
+		{"ok", "bad", []string{"probe_success 0", `probe_failure_reason{reason="config"} 1`}},
+		{"ok", "missing", []string{"probe_success 0", `probe_failure_reason{reason="config"} 1`}},
+	} {
+		q := url.Values{"target": {ts.URL + "/?status=" + tt.status}, "body_match_file": {tt.file}}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		for _, want := range tt.want {
+			if body := rec.Body.String(); !strings.Contains(body, want) {
+				t.Errorf("status=%s body_match_file=%s: %q not found in:\n%s", tt.status, tt.file, want, body)
+			}
+		}
+	}
+}
+
+func TestProbeHandlerBodyRegexp(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "` + r.URL.Query().Get("status") + `"}`))
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		status string
+		params url.Values
+		want   string
+	}{
+		{"ok", url.Values{"fail_if_body_not_matches_regexp": {`"status":\s*"ok"`, `^\{`}}, "probe_success 1"},
+		{"down", url.Values{"fail_if_body_not_matches_regexp": {`"status":\s*"ok"`}}, `probe_failure_reason{reason="body_match"} 1`},
+		{"ok", url.Values{"fail_if_body_matches_regexp": {`(?i)maintenance`}}, "probe_success 1"},
+		{"Maintenance", url.Values{"fail_if_body_matches_regexp": {`(?i)maintenance`}}, `probe_failure_reason{reason="body_forbidden_match"} 1`},
+	} {
+		q := tt.params
+		q.Set("target", ts.URL+"/?status="+tt.status)
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		if body := rec.Body.String(); !strings.Contains(body, tt.want) {
+			t.Errorf("%s: %q not found in:\n%s", q.Encode(), tt.want, body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+url.Values{"target": {ts.URL}, "fail_if_body_matches_regexp": {"(unclosed"}}.Encode(), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid regexp: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestFailedHeaderMatch(t *testing.T) {
+	h := http.Header{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Strict-Transport-Security": {"max-age=31536000"},
+		"Server":                    {"nginx/1.25.3"},
+	}
+	must := func(header, pattern string, allowMissing bool) *headerMatch {
+		m, err := newHeaderMatch(header, pattern, allowMissing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	tests := []struct {
+		required, forbidden []*headerMatch
+		want                string
+	}{
+		{[]*headerMatch{must("strict-transport-security", "", false)}, nil, ""},
+		{[]*headerMatch{must("Content-Type", "^application/json", false)}, nil, "Content-Type"},
+		{[]*headerMatch{must("X-Cache", "HIT", false)}, nil, "X-Cache"},
+		{[]*headerMatch{must("X-Cache", "HIT", true)}, nil, ""},
+		{nil, []*headerMatch{must("Server", `nginx/1\.25`, false)}, "Server"},
+		{nil, []*headerMatch{must("X-Powered-By", "", false)}, ""},
+	}
+	for i, tt := range tests {
+		if got := failedHeaderMatch(h, tt.required, tt.forbidden); got != tt.want {
+			t.Errorf("%d: failedHeaderMatch = %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestProbeHandlerHeaderMatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		params url.Values
+		want   string
+	}{
+		{url.Values{"fail_if_header_not_matches": {"Content-Type: ^application/json"}}, "probe_success 1"},
+		{url.Values{"fail_if_header_not_matches": {"Strict-Transport-Security:"}}, `probe_failure_reason{reason="header_match"} 1`},
+		{url.Values{"fail_if_header_matches": {"Content-Type: html"}}, "probe_success 1"},
+		{url.Values{"fail_if_header_matches": {"Content-Type: json"}}, `probe_failure_reason{reason="header_match"} 1`},
+	} {
+		q := tt.params
+		q.Set("target", ts.URL)
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		if body := rec.Body.String(); !strings.Contains(body, tt.want) {
+			t.Errorf("%s: %q not found in:\n%s", q.Encode(), tt.want, body)
+		}
+	}
+
+	for _, bad := range []string{"Content-Type", ": json", "Content-Type: (unclosed"} {
+		q := url.Values{"target": {ts.URL}, "fail_if_header_matches": {bad}}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("fail_if_header_matches=%q: status = %d, want 400", bad, rec.Code)
+		}
+	}
+}
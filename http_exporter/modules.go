@@ -0,0 +1,351 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// moduleConfig is the file of -config.file, defining named probe modules
+// like blackbox_exporter's:
+//
+//	modules:
+//	  api_post:
+//	    method: POST
+//	    headers:
+//	      Accept: application/json
+//	    timeout: 5s
+//	    valid_status_codes: [200, 201]
+//	    json_assert:
+//	      - $.queue_depth < 100
+type moduleConfig struct {
+	Modules map[string]*module `yaml:"modules"`
+}
+
+// module configures a probe selected with the module param. Params of the
+// probe request override it.
+type module struct {
+	Timeout        time.Duration     `yaml:"timeout"`
+	Method         string            `yaml:"method"`
+	Headers        map[string]string `yaml:"headers"`
+	Body           string            `yaml:"body"`
+	HTTP3          bool              `yaml:"http3"`           // probes over QUIC
+	TCPTLS         bool              `yaml:"tcp_tls"`         // handshakes TLS with tcp targets
+	AcceptEncoding string            `yaml:"accept_encoding"` // e.g. "br, gzip", or identity
+	Resolver       string            `yaml:"resolver"`        // DNS server, as the dns_server param
+
+	// Credentials, each given inline, in a file or in an environment variable
+	BasicAuth       *moduleBasicAuth `yaml:"basic_auth"`
+	BearerToken     string           `yaml:"bearer_token"`
+	BearerTokenFile string           `yaml:"bearer_token_file"`
+	BearerTokenEnv  string           `yaml:"bearer_token_env"`
+	OAuth2          *moduleOAuth2    `yaml:"oauth2"`
+	TLSConfig       moduleTLSConfig  `yaml:"tls_config"`
+	Warmup          bool             `yaml:"warmup"`
+
+	MaxRedirects *int `yaml:"max_redirects"` // 0 not to follow redirects
+
+	PreferredIPProtocol string `yaml:"preferred_ip_protocol"` // ip4 or ip6
+	IPProtocolFallback  *bool  `yaml:"ip_protocol_fallback"`  // true by default
+
+	Samples       int       `yaml:"samples"`
+	SampleBuckets []float64 `yaml:"sample_buckets"` // reports samples as histograms(ms)
+
+	// Success criteria
+	ValidStatusCodes []int    `yaml:"valid_status_codes"`
+	MinBodyBytes     int64    `yaml:"min_body_bytes"`
+	ExpectedSHA256   string   `yaml:"expected_sha256"` // hex, as sha256sum prints it
+	JSONAssert       []string `yaml:"json_assert"`
+	// Regexes on the body, read up to -max-body-bytes
+	FailIfBodyMatchesRegexp    []string `yaml:"fail_if_body_matches_regexp"`
+	FailIfBodyNotMatchesRegexp []string `yaml:"fail_if_body_not_matches_regexp"`
+	// Regexes on response headers, e.g. that Strict-Transport-Security is set
+	FailIfHeaderMatches    []moduleHeaderMatch `yaml:"fail_if_header_matches"`
+	FailIfHeaderNotMatches []moduleHeaderMatch `yaml:"fail_if_header_not_matches"`
+
+	name            string
+	jsonAssertions  []*jsonAssertion
+	bodyMatches     []*regexp.Regexp
+	bodyForbidden   []*regexp.Regexp
+	headerMatches   []*headerMatch
+	headerForbidden []*headerMatch
+	dnsServer       *dnsServer
+	rootCAs         *x509.CertPool
+	bearerToken     *secret
+	basicAuth       *basicAuth
+	oauth2          *oauth2Source // shared by the probes of the module, caching the token
+}
+
+// moduleOAuth2 gets bearer tokens with the client credentials grant.
+type moduleOAuth2 struct {
+	TokenURL         string            `yaml:"token_url"`
+	ClientID         string            `yaml:"client_id"`
+	ClientSecret     string            `yaml:"client_secret"`
+	ClientSecretFile string            `yaml:"client_secret_file"`
+	ClientSecretEnv  string            `yaml:"client_secret_env"`
+	Scopes           []string          `yaml:"scopes"`
+	EndpointParams   map[string]string `yaml:"endpoint_params"` // e.g. audience
+}
+
+// moduleHeaderMatch is a regex on the values of a response header. An empty
+// regexp checks only that the header is present.
+type moduleHeaderMatch struct {
+	Header       string `yaml:"header"`
+	Regexp       string `yaml:"regexp"`
+	AllowMissing bool   `yaml:"allow_missing"` // for fail_if_header_not_matches
+}
+
+type moduleBasicAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	PasswordEnv  string `yaml:"password_env"`
+}
+
+type moduleTLSConfig struct {
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	ServerName         string `yaml:"server_name"`
+	CertFile           string `yaml:"cert_file"` // client certificate for mutual TLS
+	KeyFile            string `yaml:"key_file"`
+	CAFile             string `yaml:"ca_file"` // PEM bundle replacing the system roots
+	CADir              string `yaml:"ca_dir"`  // directory of PEM files replacing the system roots
+}
+
+// modules are loaded from -config.file at startup. nil if there is none.
+var modules map[string]*module
+
+// probeMethods are the methods a module may probe with.
+var probeMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "OPTIONS": true,
+}
+
+// loadModules reads and validates the modules of the config file at path.
+func loadModules(path string) (map[string]*module, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config moduleConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, err
+	}
+	for name, m := range config.Modules {
+		if m == nil {
+			return nil, fmt.Errorf("module %s: empty", name)
+		}
+		if err := m.validate(); err != nil {
+			return nil, fmt.Errorf("module %s: %s", name, err)
+		}
+		m.name = name
+	}
+	return config.Modules, nil
+}
+
+func (m *module) validate() error {
+	if m.Timeout < 0 {
+		return fmt.Errorf("invalid timeout %s", m.Timeout)
+	}
+	if m.Method != "" && !probeMethods[m.Method] {
+		return fmt.Errorf("unsupported method %q", m.Method)
+	}
+	if m.Body != "" && m.Method == "HEAD" {
+		return fmt.Errorf("body can't be sent with HEAD")
+	}
+	if m.AcceptEncoding != "" {
+		acceptEncoding, err := parseAcceptEncoding(m.AcceptEncoding)
+		if err != nil {
+			return fmt.Errorf("invalid accept_encoding: %s", err)
+		}
+		m.AcceptEncoding = acceptEncoding
+	}
+	for _, code := range m.ValidStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid status code %d", code)
+		}
+	}
+	if m.MaxRedirects != nil && (*m.MaxRedirects < 0 || *m.MaxRedirects > maxRedirectsLimit) {
+		return fmt.Errorf("invalid max_redirects %d, must be 0 to %d", *m.MaxRedirects, maxRedirectsLimit)
+	}
+	if p := m.PreferredIPProtocol; p != "" && p != "ip4" && p != "ip6" {
+		return fmt.Errorf("invalid preferred_ip_protocol %q, must be ip4 or ip6", p)
+	}
+	if m.Samples < 0 || m.Samples > maxSamples {
+		return fmt.Errorf("invalid samples %d, must be up to %d", m.Samples, maxSamples)
+	}
+	for i, b := range m.SampleBuckets {
+		if b <= 0 || (i > 0 && b <= m.SampleBuckets[i-1]) {
+			return fmt.Errorf("sample_buckets must be positive and ascending")
+		}
+	}
+	if m.MinBodyBytes < 0 {
+		return fmt.Errorf("invalid min_body_bytes %d", m.MinBodyBytes)
+	}
+	if m.ExpectedSHA256 != "" {
+		sum, err := parseSHA256(m.ExpectedSHA256)
+		if err != nil {
+			return fmt.Errorf("invalid expected_sha256: %s", err)
+		}
+		m.ExpectedSHA256 = sum
+	}
+	if (m.TLSConfig.CertFile == "") != (m.TLSConfig.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if m.TLSConfig.CertFile != "" {
+		// Read again on each handshake, but fail early on typos
+		if _, err := tls.LoadX509KeyPair(m.TLSConfig.CertFile, m.TLSConfig.KeyFile); err != nil {
+			return fmt.Errorf("invalid client certificate: %s", err)
+		}
+	}
+	if m.TLSConfig.CAFile != "" || m.TLSConfig.CADir != "" {
+		// Unlike client certificates, loaded once, as CAs change rarely
+		pool, err := loadCAs(m.TLSConfig.CAFile, m.TLSConfig.CADir)
+		if err != nil {
+			return fmt.Errorf("invalid CA: %s", err)
+		}
+		m.rootCAs = pool
+	}
+	if m.BasicAuth != nil {
+		if m.BasicAuth.Username == "" {
+			return fmt.Errorf("basic_auth needs a username")
+		}
+		password, err := newSecret("password", m.BasicAuth.Password, m.BasicAuth.PasswordFile, m.BasicAuth.PasswordEnv)
+		if err != nil {
+			return fmt.Errorf("invalid basic_auth: %s", err)
+		}
+		if password == nil {
+			password = &secret{kind: "password"} // An empty password is valid
+		}
+		m.basicAuth = &basicAuth{username: m.BasicAuth.Username, password: password}
+	}
+	bearerToken, err := newSecret("bearer_token", m.BearerToken, m.BearerTokenFile, m.BearerTokenEnv)
+	if err != nil {
+		return err
+	}
+	if bearerToken != nil && m.basicAuth != nil {
+		return fmt.Errorf("basic_auth and bearer_token can't be set together")
+	}
+	m.bearerToken = bearerToken
+	if o := m.OAuth2; o != nil {
+		if bearerToken != nil || m.basicAuth != nil {
+			return fmt.Errorf("oauth2 can't be set with basic_auth or bearer_token")
+		}
+		if u, err := url.Parse(o.TokenURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid oauth2 token_url %q", o.TokenURL)
+		}
+		if o.ClientID == "" {
+			return fmt.Errorf("oauth2 needs a client_id")
+		}
+		clientSecret, err := newSecret("client_secret", o.ClientSecret, o.ClientSecretFile, o.ClientSecretEnv)
+		if err != nil {
+			return fmt.Errorf("invalid oauth2: %s", err)
+		}
+		if clientSecret == nil {
+			return fmt.Errorf("oauth2 needs a client_secret")
+		}
+		// The token endpoint is often internal too
+		m.oauth2 = newOAuth2Source(o.TokenURL, o.ClientID, clientSecret, o.Scopes, o.EndpointParams, &tls.Config{RootCAs: m.rootCAs})
+	}
+	if m.Resolver != "" {
+		server, err := parseDNSServer(m.Resolver)
+		if err != nil {
+			return fmt.Errorf("invalid resolver %q: %s", m.Resolver, err)
+		}
+		m.dnsServer = server
+	}
+	for _, expr := range m.JSONAssert {
+		a, err := parseJSONAssertion(expr)
+		if err != nil {
+			return fmt.Errorf("invalid json_assert %q: %s", expr, err)
+		}
+		m.jsonAssertions = append(m.jsonAssertions, a)
+	}
+	for _, expr := range m.FailIfBodyNotMatchesRegexp {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid fail_if_body_not_matches_regexp %q: %s", expr, err)
+		}
+		m.bodyMatches = append(m.bodyMatches, re)
+	}
+	for _, expr := range m.FailIfBodyMatchesRegexp {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid fail_if_body_matches_regexp %q: %s", expr, err)
+		}
+		m.bodyForbidden = append(m.bodyForbidden, re)
+	}
+	for _, h := range m.FailIfHeaderNotMatches {
+		hm, err := newHeaderMatch(h.Header, h.Regexp, h.AllowMissing)
+		if err != nil {
+			return fmt.Errorf("invalid fail_if_header_not_matches %q: %s", h.Header, err)
+		}
+		m.headerMatches = append(m.headerMatches, hm)
+	}
+	for _, h := range m.FailIfHeaderMatches {
+		hm, err := newHeaderMatch(h.Header, h.Regexp, false)
+		if err != nil {
+			return fmt.Errorf("invalid fail_if_header_matches %q: %s", h.Header, err)
+		}
+		m.headerForbidden = append(m.headerForbidden, hm)
+	}
+	return nil
+}
+
+// apply configures c with the module.
+func (m *module) apply(c *httpStatsCollector) {
+	c.module = m.name
+	c.totalTimeout = m.Timeout
+	if m.Method != "" {
+		c.method = m.Method
+	}
+	if len(m.Headers) > 0 {
+		c.headers = http.Header{}
+		for name, value := range m.Headers {
+			c.headers.Set(name, value)
+		}
+	}
+	if m.Body != "" {
+		c.body = []byte(m.Body)
+	}
+	if m.AcceptEncoding != "" {
+		c.acceptEncoding = m.AcceptEncoding
+	}
+	c.insecureSkipVerify = m.TLSConfig.InsecureSkipVerify
+	c.serverName = m.TLSConfig.ServerName
+	c.rootCAs = m.rootCAs
+	c.bearerToken = m.bearerToken
+	c.basicAuth = m.basicAuth
+	c.oauth2 = m.oauth2
+	if m.TLSConfig.CertFile != "" {
+		c.clientCert = &clientCert{certFile: m.TLSConfig.CertFile, keyFile: m.TLSConfig.KeyFile}
+	}
+	c.warmup = m.Warmup
+	c.http3 = m.HTTP3
+	c.tcpTLS = m.TCPTLS
+	c.preferredIP = m.PreferredIPProtocol
+	c.dnsServer = m.dnsServer
+	if m.IPProtocolFallback != nil {
+		c.ipFallback = *m.IPProtocolFallback
+	}
+	if m.MaxRedirects != nil {
+		c.maxRedirects = *m.MaxRedirects
+	}
+	c.samples = m.Samples
+	if len(m.SampleBuckets) > 0 {
+		c.sampleBuckets = m.SampleBuckets
+	}
+	c.validStatusCodes = m.ValidStatusCodes
+	c.minBodyBytes = m.MinBodyBytes
+	c.expectedSHA256 = m.ExpectedSHA256
+	c.jsonAssertions = append([]*jsonAssertion(nil), m.jsonAssertions...)
+	c.bodyMatches = append([]*regexp.Regexp(nil), m.bodyMatches...)
+	c.bodyForbidden = append([]*regexp.Regexp(nil), m.bodyForbidden...)
+	c.headerMatches = append([]*headerMatch(nil), m.headerMatches...)
+	c.headerForbidden = append([]*headerMatch(nil), m.headerForbidden...)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadModules(t *testing.T) {
+	m, err := loadModules(writeConfig(t, `
+modules:
+  api_post:
+    method: POST
+    headers:
+      Accept: application/json
+    timeout: 5s
+    resolver: 1.1.1.1
+    valid_status_codes: [200, 201]
+    json_assert:
+      - $.queue_depth < 100
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := m["api_post"]
+	if api == nil || api.Method != "POST" || api.Timeout != 5*time.Second || len(api.jsonAssertions) != 1 {
+		t.Errorf("api_post = %+v", api)
+	}
+	if api.dnsServer == nil || api.dnsServer.addr != "1.1.1.1:53" {
+		t.Errorf("api_post resolver = %+v, want 1.1.1.1:53", api.dnsServer)
+	}
+
+	for _, bad := range []string{
+		"modules:\n  m:\n    method: FETCH\n",
+		"modules:\n  m:\n    valid_status_codes: [2000]\n",
+		"modules:\n  m:\n    json_assert: [\"$.status ~ 1\"]\n",
+		"modules:\n  m:\n    fail_if_body_matches_regexp: [\"(unclosed\"]\n",
+		"modules:\n  m:\n    fail_if_header_not_matches:\n      - regexp: json\n",
+		"modules:\n  m:\n    unknown_option: true\n",
+		"modules:\n  m:\n    resolver: dns.google\n",
+		"modules:\n  m:\n",
+	} {
+		if _, err := loadModules(writeConfig(t, bad)); err == nil {
+			t.Errorf("loadModules(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestProbeHandlerModule(t *testing.T) {
+	var err error
+	modules, err = loadModules(writeConfig(t, `
+modules:
+  api_post:
+    method: POST
+    headers:
+      Accept: application/json
+    valid_status_codes: [201]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { modules = nil }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.Header.Get("Accept") == "application/json" {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer ts.Close()
+
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+url.Values{"target": {ts.URL}, "module": {"api_post"}}.Encode(), nil))
+	if body := rec.Body.String(); !strings.Contains(body, "probe_success 1") {
+		t.Errorf("probe_success 1 not found in:\n%s", body)
+	}
+
+	// A plain GET gets a 200, which the module doesn't accept
+	modules["api_post"].Method = ""
+	rec = httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+url.Values{"target": {ts.URL}, "module": {"api_post"}}.Encode(), nil))
+	if body := rec.Body.String(); !strings.Contains(body, `reason="status_code"`) {
+		t.Errorf(`reason="status_code" not found in:\n%s`, body)
+	}
+
+	rec = httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+url.Values{"target": {ts.URL}, "module": {"missing"}}.Encode(), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status for an unknown module = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	// oauth2Timeout bounds token requests, which probes wait for.
+	oauth2Timeout = 10 * time.Second
+	// oauth2ExpiryDelta is how long before its expiry a token is refreshed,
+	// so that it doesn't expire in flight.
+	oauth2ExpiryDelta = 10 * time.Second
+)
+
+// oauth2Source gets access tokens with the OAuth 2.0 client credentials
+// grant, caching them across probes until shortly before they expire.
+type oauth2Source struct {
+	config       clientcredentials.Config // without the secret, read per request
+	clientSecret *secret
+	client       *http.Client
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+func newOAuth2Source(tokenURL, clientID string, clientSecret *secret, scopes []string, endpointParams map[string]string, tlsConfig *tls.Config) *oauth2Source {
+	params := url.Values{}
+	for k, v := range endpointParams {
+		params.Set(k, v)
+	}
+	return &oauth2Source{
+		config: clientcredentials.Config{
+			ClientID:       clientID,
+			TokenURL:       tokenURL,
+			Scopes:         scopes,
+			EndpointParams: params,
+			AuthStyle:      oauth2.AuthStyleInHeader,
+		},
+		clientSecret: clientSecret,
+		client: &http.Client{
+			Timeout:   oauth2Timeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		},
+	}
+}
+
+// accessToken returns the cached token, getting a new one if it is about
+// to expire. The token request is made without holding the cache, so that
+// a slow token endpoint delays only the probes needing a new token; those
+// may each request one.
+func (o *oauth2Source) accessToken(ctx context.Context) (string, error) {
+	o.mu.Lock()
+	token := o.token
+	o.mu.Unlock()
+	if token != nil && (token.Expiry.IsZero() || time.Now().Add(oauth2ExpiryDelta).Before(token.Expiry)) {
+		return token.AccessToken, nil
+	}
+
+	token, err := o.requestToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w: oauth2 token request: %s", errAuth, err)
+	}
+	o.mu.Lock()
+	o.token = token
+	o.mu.Unlock()
+	return token.AccessToken, nil
+}
+
+func (o *oauth2Source) requestToken(ctx context.Context) (*oauth2.Token, error) {
+	clientSecret, err := o.clientSecret.get()
+	if err != nil {
+		return nil, err
+	}
+	config := o.config
+	config.ClientSecret = clientSecret
+	token, err := config.Token(context.WithValue(ctx, oauth2.HTTPClient, o.client))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(token.Type(), "bearer") {
+		return nil, fmt.Errorf("unsupported token_type %q", token.TokenType)
+	}
+	return token, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTokenServer returns a token endpoint granting tokens that expire in
+// expiresIn seconds to client:s3cret, and a count of the tokens granted.
+func newTokenServer(t *testing.T, expiresIn int) (*httptest.Server, *int32) {
+	var granted int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&granted, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d,"scope":%q}`, n, expiresIn, r.FormValue("scope"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &granted
+}
+
+func TestOAuth2SourceCaches(t *testing.T) {
+	tests := []struct {
+		expiresIn int
+		want      string // token of the second call
+	}{
+		{3600, "token-1"},
+		{5, "token-2"}, // within oauth2ExpiryDelta
+		{0, "token-1"}, // doesn't expire
+	}
+	for _, tt := range tests {
+		ts, _ := newTokenServer(t, tt.expiresIn)
+		o := newOAuth2Source(ts.URL, "client", &secret{value: "s3cret"}, []string{"probe"}, nil, nil)
+		for i, want := range []string{"token-1", tt.want} {
+			got, err := o.accessToken(context.Background())
+			if err != nil || got != want {
+				t.Errorf("expires_in %d: call %d = %q, %v, want %q", tt.expiresIn, i+1, got, err, want)
+			}
+		}
+	}
+
+	ts, _ := newTokenServer(t, 3600)
+	o := newOAuth2Source(ts.URL, "client", &secret{value: "wrong"}, nil, nil, nil)
+	if _, err := o.accessToken(context.Background()); !errors.Is(err, errAuth) {
+		t.Errorf("accessToken with a wrong secret error = %v, want %v", err, errAuth)
+	}
+}
+
+func TestOAuth2SourceSlowEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	o := newOAuth2Source(ts.URL, "client", &secret{value: "s3cret"}, nil, nil, nil)
+	go o.accessToken(context.Background())
+
+	// A probe isn't held up by another one's token request
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := o.accessToken(ctx); !errors.Is(err, errAuth) {
+		t.Errorf("accessToken error = %v, want %v", err, errAuth)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("accessToken took %s, want it bounded by its context", d)
+	}
+}
+
+func TestProbeHandlerModuleOAuth2(t *testing.T) {
+	tokenServer, granted := newTokenServer(t, 3600)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	t.Setenv("PROBE_CLIENT_SECRET", "s3cret")
+	var err error
+	modules, err = loadModules(writeConfig(t, `
+modules:
+  oauth2:
+    oauth2:
+      token_url: `+tokenServer.URL+`
+      client_id: client
+      client_secret_env: PROBE_CLIENT_SECRET
+      scopes: [probe]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { modules = nil }()
+
+	for i := 0; i < 2; i++ {
+		q := url.Values{"target": {ts.URL}, "module": {"oauth2"}}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		if body := rec.Body.String(); !strings.Contains(body, "probe_success 1") {
+			t.Errorf("probe %d: probe_success 1 not found in:\n%s", i+1, body)
+		}
+	}
+	if n := atomic.LoadInt32(granted); n != 1 {
+		t.Errorf("tokens granted = %d, want 1", n)
+	}
+
+	for _, bad := range []string{
+		"modules:\n  m:\n    oauth2:\n      token_url: /token\n      client_id: c\n      client_secret: s\n",
+		"modules:\n  m:\n    oauth2:\n      token_url: https://idp/token\n      client_id: c\n",
+		"modules:\n  m:\n    bearer_token: t\n    oauth2:\n      token_url: https://idp/token\n      client_id: c\n      client_secret: s\n",
+	} {
+		if _, err := loadModules(writeConfig(t, bad)); err == nil {
+			t.Errorf("loadModules(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pathsCollector probes several paths of a target, sharing one transport so
+// that the paths are probed over kept-alive connections.
+type pathsCollector struct {
+	collectors  []*httpStatsCollector
+	concurrency int
+}
+
+// newPathsCollector returns a collector probing each of paths on the host
+// of target, configured like base and labeled by path. At most concurrency
+// paths are probed at a time, each over its own connection.
+func newPathsCollector(base *httpStatsCollector, target *url.URL, paths []string, concurrency int) (*pathsCollector, error) {
+	if len(paths) > *maxPaths {
+		return nil, fmt.Errorf("at most %d paths can be probed", *maxPaths)
+	}
+
+	transport := base.newTransport()
+	transport.MaxConnsPerHost = concurrency
+	p := &pathsCollector{concurrency: concurrency}
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		ref, err := url.Parse(path)
+		if err != nil || !strings.HasPrefix(path, "/") || ref.Host != "" {
+			return nil, fmt.Errorf("%q is not an absolute path", path)
+		}
+		if seen[path] {
+			return nil, fmt.Errorf("%q is given twice", path)
+		}
+		seen[path] = true
+
+		u := *target
+		u.Path, u.RawPath, u.RawQuery = ref.Path, ref.RawPath, ref.RawQuery
+		constLabels := prometheus.Labels{"path": path}
+		for name, value := range base.constLabels {
+			constLabels[name] = value
+		}
+		c := base.forTarget(u.String(), constLabels)
+		c.transport = transport
+		p.collectors = append(p.collectors, c)
+	}
+	return p, nil
+}
+
+func (p *pathsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range p.collectors {
+		c.Describe(ch)
+	}
+}
+
+func (p *pathsCollector) Collect(ch chan<- prometheus.Metric) {
+	sem := make(chan struct{}, p.concurrency)
+	var wg sync.WaitGroup
+	for _, c := range p.collectors {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(c *httpStatsCollector) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			c.Collect(ch)
+		}(c)
+	}
+	wg.Wait()
+}
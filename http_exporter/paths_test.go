@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProbeHandlerPaths(t *testing.T) {
+	var conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	q := url.Values{"target": {ts.URL}, "paths": {"/a", "/b?x=1", "/missing"}}
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`probe_success{path="/a"} 1`,
+		`probe_success{path="/b?x=1"} 1`,
+		`ttfb{path="/missing",status_code="4xx"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%q not found in:\n%s", want, body)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("connections = %d, want 1", n)
+	}
+
+	for _, paths := range [][]string{{"a"}, {"//evil.example.com/"}, {"/a", "/a"}} {
+		q := url.Values{"target": {ts.URL}, "paths": paths}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("paths=%q: status = %d, want %d", paths, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestProbeHandlerPathsRequestBudget(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer ts.Close()
+
+	*maxRequests = 2
+	defer func() { *maxRequests = 0 }()
+	q := url.Values{"target": {ts.URL}, "paths": {"/a", "/b", "/c", "/d"}}
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("requests = %d, want the budget of the scrape, 2", n)
+	}
+	if body := rec.Body.String(); strings.Count(body, `reason="request_budget"} 1`) != 2 {
+		t.Errorf("2 request_budget failures not found in:\n%s", body)
+	}
+}
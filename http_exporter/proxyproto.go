@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// proxyProtocol prepends a PROXY protocol header to probe connections, for
+// backends behind load balancers that reject connections without one.
+type proxyProtocol struct {
+	version int          // 1 for the text format, 2 for the binary one
+	src     *net.TCPAddr // spoofed source address, the local one if nil
+	dst     *net.TCPAddr // spoofed destination address, the remote one if nil
+}
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// parseProxyProtocol parses the proxy_protocol param, v1 or v2, and the
+// optional ip:port addresses to send instead of the connection's.
+func parseProxyProtocol(version, src, dst string) (*proxyProtocol, error) {
+	p := &proxyProtocol{}
+	switch version {
+	case "v1", "1":
+		p.version = 1
+	case "v2", "2":
+		p.version = 2
+	default:
+		return nil, fmt.Errorf("unsupported version %q", version)
+	}
+	var err error
+	if p.src, err = parseProxyAddr(src); err != nil {
+		return nil, fmt.Errorf("source: %s", err)
+	}
+	if p.dst, err = parseProxyAddr(dst); err != nil {
+		return nil, fmt.Errorf("destination: %s", err)
+	}
+	return p, nil
+}
+
+func parseProxyAddr(s string) (*net.TCPAddr, error) {
+	if s == "" {
+		return nil, nil
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP %q", host)
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(n)}, nil
+}
+
+// header returns the header of a connection from local to remote.
+func (p *proxyProtocol) header(local, remote net.Addr) ([]byte, error) {
+	src, dst := p.src, p.dst
+	if src == nil {
+		src, _ = local.(*net.TCPAddr)
+	}
+	if dst == nil {
+		dst, _ = remote.(*net.TCPAddr)
+	}
+	if src == nil || dst == nil {
+		return nil, errors.New("PROXY protocol requires TCP addresses")
+	}
+	v4 := src.IP.To4() != nil
+	if v4 != (dst.IP.To4() != nil) {
+		return nil, errors.New("PROXY protocol addresses must be of the same family")
+	}
+
+	if p.version == 1 {
+		family := "TCP6"
+		if v4 {
+			family = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src.IP, dst.IP, src.Port, dst.Port)), nil
+	}
+
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	buf.WriteByte(0x21) // version 2, PROXY command
+	var addrs []byte
+	if v4 {
+		buf.WriteByte(0x11) // TCP over IPv4
+		addrs = append(append(addrs, src.IP.To4()...), dst.IP.To4()...)
+	} else {
+		buf.WriteByte(0x21) // TCP over IPv6
+		addrs = append(append(addrs, src.IP.To16()...), dst.IP.To16()...)
+	}
+	addrs = append(addrs, byte(src.Port>>8), byte(src.Port), byte(dst.Port>>8), byte(dst.Port))
+	binary.Write(&buf, binary.BigEndian, uint16(len(addrs)))
+	buf.Write(addrs)
+	return buf.Bytes(), nil
+}
+
+// wrapDial returns dial sending the header on every new connection, before
+// anything else such as a TLS handshake.
+func (p *proxyProtocol) wrapDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		header, err := p.header(conn.LocalAddr(), conn.RemoteAddr())
+		if err == nil {
+			_, err = conn.Write(header)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
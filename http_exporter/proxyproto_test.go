@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// proxyV1Listener reads the PROXY v1 header of each accepted connection,
+// sending it to headers if set.
+type proxyV1Listener struct {
+	net.Listener
+	headers chan string
+}
+
+func (l proxyV1Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if l.headers != nil {
+		l.headers <- line
+	}
+	return &proxyV1Conn{Conn: conn, r: r, header: line}, nil
+}
+
+type proxyV1Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	header string
+}
+
+func (c *proxyV1Conn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func TestVisitProxyProtocolV1(t *testing.T) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Listener = proxyV1Listener{Listener: ts.Listener}
+	var got string
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			got = conn.(*proxyV1Conn).header
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	c := newHTTPStatsCollector(ts.URL, 10, defaultPhaseLabels, nil)
+	var err error
+	c.proxyProtocol, err = parseProxyProtocol("v1", "192.0.2.1:4321", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, resp, err := c.visit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	want := "PROXY TCP4 192.0.2.1 127.0.0.1 4321 " + port + "\r\n"
+	if got != want {
+		t.Errorf("header = %q, want %q", got, want)
+	}
+}
+
+func TestProxyProtocolV2Header(t *testing.T) {
+	p, err := parseProxyProtocol("v2", "192.0.2.1:4321", "198.51.100.2:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.header(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte("\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x0c"),
+		192, 0, 2, 1, 198, 51, 100, 2, 0x10, 0xe1, 0x01, 0xbb)
+	if !bytes.Equal(got, want) {
+		t.Errorf("header = %x, want %x", got, want)
+	}
+
+	p, _ = parseProxyProtocol("v2", "192.0.2.1:4321", "[2001:db8::1]:443")
+	if _, err := p.header(nil, nil); err == nil {
+		t.Error("header of mixed families succeeded, want error")
+	}
+}
+
+func TestParseProxyProtocol(t *testing.T) {
+	for _, bad := range [][3]string{{"v3", "", ""}, {"v1", "192.0.2.1", ""}, {"v1", "", "example.com:80"}} {
+		if _, err := parseProxyProtocol(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("parseProxyProtocol(%q, %q, %q) succeeded, want error", bad[0], bad[1], bad[2])
+		}
+	}
+}
+
+func TestProbeHandlerProxyProtocolProbeTypes(t *testing.T) {
+	headers := make(chan string, 10)
+	listen := func(l net.Listener) net.Listener { return proxyV1Listener{l, headers} }
+
+	plain := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	plain.Listener = listen(plain.Listener)
+	plain.Start()
+	defer plain.Close()
+
+	tlsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tlsServer.Listener = listen(tlsServer.Listener)
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+
+	ws := httptest.NewUnstartedServer(websocket.Handler(func(ws *websocket.Conn) { io.Copy(ws, ws) }))
+	ws.Listener = listen(ws.Listener)
+	ws.Start()
+	defer ws.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := grpc.NewServer()
+	healthpb.RegisterHealthServer(g, health.NewServer())
+	go g.Serve(listen(l))
+	defer g.Stop()
+
+	for _, tt := range []struct {
+		name   string
+		params url.Values
+	}{
+		{"tcp", url.Values{"target": {"tcp://" + plain.Listener.Addr().String()}}},
+		{"tls_only", url.Values{"target": {tlsServer.URL}, "tls_only": {"true"}, "insecure_skip_verify": {"true"}}},
+		{"websocket_echo", url.Values{"target": {ws.URL}, "websocket_echo": {"true"}}},
+		{"grpc", url.Values{"target": {"http://" + l.Addr().String()}, "grpc": {"true"}}},
+	} {
+		tt.params.Set("proxy_protocol", "v1")
+		tt.params.Set("proxy_protocol_src", "192.0.2.1:4321")
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+tt.params.Encode(), nil))
+		if body := rec.Body.String(); !strings.Contains(body, "probe_success 1") {
+			t.Errorf("%s: probe_success 1 not found in:\n%s", tt.name, body)
+		}
+		select {
+		case h := <-headers:
+			if !strings.HasPrefix(h, "PROXY TCP4 192.0.2.1 127.0.0.1 4321 ") {
+				t.Errorf("%s: header = %q", tt.name, h)
+			}
+		default:
+			t.Errorf("%s: no PROXY header sent", tt.name)
+		}
+	}
+}
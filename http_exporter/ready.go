@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ready is set to 1 once the exporter is ready to serve probes.
+var ready int32
+
+// readyHandler answers 200 once the exporter is ready, 503 until then.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&ready) == 0 {
+		http.Error(w, "Not ready", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "Ready")
+}
+
+// startupCheck probes url until it succeeds or timeout passes, then marks
+// the exporter ready either way. It catches a bad CA bundle or missing
+// egress at boot rather than at the first scrape.
+func startupCheck(url string, timeout time.Duration) {
+	defer atomic.StoreInt32(&ready, 1)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := checkOnce(url)
+		if err == nil {
+			log.Printf("Startup check of %s passed", url)
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Printf("Startup check of %s FAILED, marking ready anyway: %s", url, err)
+			return
+		}
+		log.Printf("Startup check of %s failed, retrying: %s", url, err)
+		time.Sleep(time.Second)
+	}
+}
+
+func checkOnce(url string) error {
+	c := newProbeCollector(url, defaultScheduledTimeout, prometheus.Labels{})
+	_, resp, err := c.visit()
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if !c.statusCodeValid(resp.StatusCode) {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartupCheck(t *testing.T) {
+	defer atomic.StoreInt32(&ready, 0)
+
+	failing := int32(2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&failing, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	get := func() int {
+		rec := httptest.NewRecorder()
+		readyHandler(rec, httptest.NewRequest("GET", "/ready", nil))
+		return rec.Code
+	}
+
+	atomic.StoreInt32(&ready, 0)
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Errorf("before check: status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	startupCheck(ts.URL, time.Minute)
+	if code := get(); code != http.StatusOK {
+		t.Errorf("after check: status = %d, want %d", code, http.StatusOK)
+	}
+	if failing >= 0 {
+		t.Errorf("check passed before the target recovered")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxRetries bounds the retries param.
+const maxRetries = 5
+
+// defaultRetryOn are the statuses retried if the retry_on param isn't set.
+// Others such as 500 or 501 are unlikely to go away on a retry.
+var defaultRetryOn = []int{502, 503, 504}
+
+// parseRetryOn parses a comma separated list of status codes, e.g.
+// "502,503,504".
+func parseRetryOn(s string) ([]int, error) {
+	var codes []int
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		code, err := strconv.Atoi(f)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status code %q", f)
+		}
+		codes = append(codes, code)
+	}
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("no status codes")
+	}
+	return codes, nil
+}
+
+// shouldRetry reports whether a response with code is retried.
+func (c *httpStatsCollector) shouldRetry(code int) bool {
+	return containsInt(c.retryOn, code)
+}
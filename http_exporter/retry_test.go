@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseRetryOn(t *testing.T) {
+	codes, err := parseRetryOn("502, 503")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 || codes[0] != 502 || codes[1] != 503 {
+		t.Errorf("parseRetryOn = %v, want [502 503]", codes)
+	}
+	for _, bad := range []string{"", "5xx", "600"} {
+		if _, err := parseRetryOn(bad); err == nil {
+			t.Errorf("parseRetryOn(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestProbeHandlerRetries(t *testing.T) {
+	tests := []struct {
+		statuses []int
+		retryOn  string
+		want     string
+	}{
+		{[]int{503, 503, 200}, "", "probe_attempts_total 3"},
+		{[]int{503, 503, 503, 503}, "", "probe_attempts_total 3"},
+		// 501 isn't retried by default
+		{[]int{501, 200}, "", "probe_attempts_total 1"},
+		{[]int{501, 200}, "501", "probe_attempts_total 2"},
+	}
+	for _, tt := range tests {
+		var requests int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.statuses[requests])
+			requests++
+		}))
+
+		q := url.Values{"target": {ts.URL}, "retries": {"2"}}
+		if tt.retryOn != "" {
+			q.Set("retry_on", tt.retryOn)
+		}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		if body := rec.Body.String(); !strings.Contains(body, tt.want) {
+			t.Errorf("statuses %v, retry_on %q: %s not found in:\n%s", tt.statuses, tt.retryOn, tt.want, body)
+		}
+		ts.Close()
+	}
+}
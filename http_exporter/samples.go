@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxSamples bounds the samples param.
+const maxSamples = 100
+
+// samplePhases are the phases summarized over the samples of a probe.
+var samplePhases = []struct {
+	name     string
+	duration func(*stats) time.Duration
+}{
+	{"dns_lookup_time", (*stats).dnsLookup},
+	{"tcp_handshake_time", (*stats).tcpConnection},
+	{"tls_handshake_time", (*stats).tlsHandshake},
+	{"server_processing_time", (*stats).serverProcessing},
+	{"content_transfer_time", (*stats).contentTransfer},
+	{"ttfb", (*stats).ttfb},
+}
+
+// percentilesFlag is a comma separated list of percentiles, e.g. "50,95,99.9".
+type percentilesFlag []float64
+
+func (p *percentilesFlag) String() string {
+	s := make([]string, len(*p))
+	for i, v := range *p {
+		s[i] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strings.Join(s, ",")
+}
+
+func (p *percentilesFlag) Set(value string) error {
+	var percentiles []float64
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v <= 0 || v > 100 {
+			return fmt.Errorf("invalid percentile %q", s)
+		}
+		percentiles = append(percentiles, v)
+	}
+	*p = percentiles
+	return nil
+}
+
+// bucketsFlag is a comma separated list of ascending histogram bucket upper
+// bounds(ms), e.g. "10,50,100,500".
+type bucketsFlag []float64
+
+func (b *bucketsFlag) String() string {
+	s := make([]string, len(*b))
+	for i, v := range *b {
+		s[i] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strings.Join(s, ",")
+}
+
+func (b *bucketsFlag) Set(value string) error {
+	var buckets []float64
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("invalid bucket %q", s)
+		}
+		if len(buckets) > 0 && v <= buckets[len(buckets)-1] {
+			return fmt.Errorf("buckets must be ascending, got %q after %v", s, buckets[len(buckets)-1])
+		}
+		buckets = append(buckets, v)
+	}
+	*b = buckets
+	return nil
+}
+
+// sampleStatNames returns the statistics reported over samples, e.g. p95 or
+// p99_9 for the 99.9th percentile.
+func sampleStatNames(percentiles []float64) []string {
+	names := []string{"min", "avg", "max"}
+	for _, p := range percentiles {
+		names = append(names, "p"+strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", 1))
+	}
+	return names
+}
+
+// percentile returns the p-th percentile of sorted by the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(float64(len(sorted))*p/100+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func (c *httpStatsCollector) sampleDesc(phase, stat string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		phase+"_"+stat,
+		fmt.Sprintf("The %s of %s over the samples of the probe(ms)", stat, phase),
+		nil,
+		c.constLabels,
+	)
+}
+
+// sampleHistogramDesc describes the histogram of phase over the samples,
+// sent instead of the statistics if sampleBuckets is set.
+func (c *httpStatsCollector) sampleHistogramDesc(phase string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		phase+"_samples",
+		fmt.Sprintf("A histogram of %s over the samples of the probe(ms)", phase),
+		nil,
+		c.constLabels,
+	)
+}
+
+func (c *httpStatsCollector) describeSamples(ch chan<- *prometheus.Desc) {
+	for _, phase := range samplePhases {
+		if len(c.sampleBuckets) > 0 {
+			ch <- c.sampleHistogramDesc(phase.name)
+			continue
+		}
+		for _, stat := range sampleStatNames(c.samplePercentiles) {
+			ch <- c.sampleDesc(phase.name, stat)
+		}
+	}
+}
+
+// collectSamples takes c.samples-1 more samples besides first and sends
+// the statistics of each phase over all of them. Failed samples are left out.
+func (c *httpStatsCollector) collectSamples(ch chan<- prometheus.Metric, first stats) {
+	durations := make([][]time.Duration, len(samplePhases))
+	add := func(s *stats) {
+		for i, phase := range samplePhases {
+			durations[i] = append(durations[i], phase.duration(s))
+		}
+	}
+
+	add(&first)
+	for i := 1; i < c.samples; i++ {
+		s, resp, err := c.visit()
+		if err != nil {
+			log.Printf("Sample visit error: %s", err)
+			continue
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if !c.headOnlyTiming {
+			s.Finish = time.Now() // as for the first sample, with the body
+		}
+		add(&s)
+	}
+
+	for i, phase := range samplePhases {
+		d := durations[i]
+		if len(c.sampleBuckets) > 0 {
+			c.sendSampleHistogram(ch, phase.name, d)
+			continue
+		}
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		var sum time.Duration
+		for _, v := range d {
+			sum += v
+		}
+
+		values := []time.Duration{d[0], sum / time.Duration(len(d)), d[len(d)-1]}
+		for _, p := range c.samplePercentiles {
+			values = append(values, percentile(d, p))
+		}
+		for j, stat := range sampleStatNames(c.samplePercentiles) {
+			sendGauge(ch, c.sampleDesc(phase.name, stat), ns2ms(values[j]))
+		}
+	}
+}
+
+// sendSampleHistogram sends the histogram of the durations d of phase.
+func (c *httpStatsCollector) sendSampleHistogram(ch chan<- prometheus.Metric, phase string, d []time.Duration) {
+	buckets := make(map[float64]uint64, len(c.sampleBuckets))
+	var sum float64
+	for _, v := range d {
+		ms := ns2ms(v)
+		sum += ms
+		for _, upper := range c.sampleBuckets {
+			if ms <= upper {
+				buckets[upper]++
+			}
+		}
+	}
+	m, err := prometheus.NewConstHistogram(c.sampleHistogramDesc(phase), uint64(len(d)), sum, buckets)
+	if err != nil {
+		log.Printf("%s histogram generation error: %s", phase, err)
+		return
+	}
+	ch <- m
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 20; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 10},
+		{95, 19},
+		{99, 20},
+		{100, 20},
+		{1, 1},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(1..20, %v) = %d, want %d", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestPercentilesFlag(t *testing.T) {
+	var p percentilesFlag
+	if err := p.Set("50, 99.9"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(sampleStatNames(p), ","), "min,avg,max,p50,p99_9"; got != want {
+		t.Errorf("sampleStatNames = %s, want %s", got, want)
+	}
+	for _, bad := range []string{"0", "101", "p95"} {
+		if err := p.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestProbeHandlerSamples(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+
+	q := url.Values{"target": {ts.URL}, "samples": {"5"}}
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+	if requests != 5 {
+		t.Errorf("requests = %d, want 5", requests)
+	}
+	for _, want := range []string{"ttfb_min ", "ttfb_avg ", "ttfb_max ", "ttfb_p95 ", "dns_lookup_time_p95 ",
+		"probe_requests_total 5", "probe_attempts_total 5"} {
+		if body := rec.Body.String(); !strings.Contains(body, want) {
+			t.Errorf("%q not found in:\n%s", want, body)
+		}
+	}
+}
+
+func TestProbeHandlerSampleHistograms(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	defer func(b bucketsFlag) { sampleBuckets = b }(sampleBuckets)
+	if err := sampleBuckets.Set("10,100000"); err != nil {
+		t.Fatal(err)
+	}
+
+	q := url.Values{"target": {ts.URL}, "samples": {"3"}}
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+	body := rec.Body.String()
+	for _, want := range []string{`ttfb_samples_bucket{le="100000"} 3`, `ttfb_samples_bucket{le="+Inf"} 3`, "ttfb_samples_count 3"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%q not found in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "ttfb_p95 ") {
+		t.Errorf("percentiles reported with histograms:\n%s", body)
+	}
+	for _, bad := range []string{"0", "10,5", "x"} {
+		var b bucketsFlag
+		if err := b.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxSilence bounds the duration of a silence, so that a forgotten one
+// doesn't mute a target for good.
+const maxSilence = 7 * 24 * time.Hour
+
+// maxSilences bounds the number of silences, as anyone reaching
+// /silence can add them.
+const maxSilences = 1000
+
+var errTooManySilences = errors.New("too many silences")
+
+// silences are the targets whose Slack alerts are muted for maintenance.
+// Probes still run and report metrics.
+type silences struct {
+	mu      sync.Mutex
+	targets map[string]time.Time // target to the end of its silence
+}
+
+func newSilences() *silences {
+	return &silences{targets: make(map[string]time.Time)}
+}
+
+// alertSilences is shared by the alert path and silenceHandler.
+var alertSilences = newSilences()
+
+// add silences target until d from now.
+func (s *silences) add(target string, d time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	if _, ok := s.targets[target]; !ok && len(s.targets) >= maxSilences {
+		return time.Time{}, errTooManySilences
+	}
+	until := time.Now().Add(d)
+	s.targets[target] = until
+	return until, nil
+}
+
+func (s *silences) remove(target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.targets, target)
+}
+
+// active reports whether target is silenced, forgetting expired silences.
+func (s *silences) active(target string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.targets[target]
+	if ok && !time.Now().Before(until) {
+		delete(s.targets, target)
+		return false
+	}
+	return ok
+}
+
+// list returns the active silences as "<target> until <time>", sorted by
+// target, forgetting expired ones.
+func (s *silences) list() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	lines := make([]string, 0, len(s.targets))
+	for t, until := range s.targets {
+		lines = append(lines, fmt.Sprintf("%s until %s", t, until.Format(time.RFC3339)))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// prune forgets expired silences. s.mu must be held.
+func (s *silences) prune() {
+	now := time.Now()
+	for t, until := range s.targets {
+		if !now.Before(until) {
+			delete(s.targets, t)
+		}
+	}
+}
+
+// silenceHandler manages silences: POST /silence?target=<URL>&duration=2h
+// silences a target, DELETE /silence?target=<URL> lifts it, and GET lists
+// the active silences.
+func silenceHandler(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	switch r.Method {
+	case http.MethodGet:
+		for _, l := range alertSilences.list() {
+			fmt.Fprintln(w, l)
+		}
+	case http.MethodPost:
+		if target == "" {
+			http.Error(w, "Target param is missing", http.StatusBadRequest)
+			return
+		}
+		d, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil || d <= 0 || d > maxSilence {
+			http.Error(w, fmt.Sprintf("Invalid duration param, must be up to %s", maxSilence), http.StatusBadRequest)
+			return
+		}
+		until, err := alertSilences.add(target, d)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Too many silences, up to %d", maxSilences), http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprintf(w, "%s silenced until %s\n", target, until.Format(time.RFC3339))
+	case http.MethodDelete:
+		if target == "" {
+			http.Error(w, "Target param is missing", http.StatusBadRequest)
+			return
+		}
+		alertSilences.remove(target)
+		fmt.Fprintf(w, "%s unsilenced\n", target)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
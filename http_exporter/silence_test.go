@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSilences(t *testing.T) {
+	s := newSilences()
+	const target = "https://example.com"
+
+	s.add(target, time.Hour)
+	if !s.active(target) {
+		t.Fatal("target not silenced")
+	}
+	if s.active("https://example.org") {
+		t.Error("another target silenced")
+	}
+
+	s.targets[target] = time.Now()
+	if s.active(target) {
+		t.Error("target still silenced after the silence ended")
+	}
+	if _, ok := s.targets[target]; ok {
+		t.Error("expired silence not forgotten")
+	}
+
+	// Adding and listing forget the expired silences of other targets too
+	s.targets["https://expired.example.com"] = time.Now()
+	s.add(target, time.Hour)
+	if got := s.list(); len(got) != 1 || !strings.HasPrefix(got[0], target+" until ") {
+		t.Errorf("list = %q, want only the silence of %s", got, target)
+	}
+	s.targets["https://expired.example.com"] = time.Now()
+	s.list()
+	if len(s.targets) != 1 {
+		t.Errorf("%d silences kept, want 1", len(s.targets))
+	}
+
+	for i := len(s.targets); i < maxSilences; i++ {
+		s.add(fmt.Sprintf("https://%d.example.com", i), time.Hour)
+	}
+	if _, err := s.add("https://example.org", time.Hour); err != errTooManySilences {
+		t.Errorf("add beyond maxSilences error = %v, want %v", err, errTooManySilences)
+	}
+	if _, err := s.add(target, 2*time.Hour); err != nil {
+		t.Errorf("extending a silence beyond maxSilences error = %v", err)
+	}
+}
+
+func TestSilenceHandler(t *testing.T) {
+	defer func() { alertSilences = newSilences() }()
+	const target = "https://example.com"
+
+	rec := httptest.NewRecorder()
+	silenceHandler(rec, httptest.NewRequest("POST", "/silence?target="+target+"&duration=2h", nil))
+	if rec.Code != http.StatusOK || !alertSilences.active(target) {
+		t.Fatalf("POST: status %d, silenced %v", rec.Code, alertSilences.active(target))
+	}
+
+	rec = httptest.NewRecorder()
+	silenceHandler(rec, httptest.NewRequest("GET", "/silence", nil))
+	if !strings.HasPrefix(rec.Body.String(), target+" until ") {
+		t.Errorf("GET body = %q, want the silence of %s", rec.Body.String(), target)
+	}
+
+	rec = httptest.NewRecorder()
+	silenceHandler(rec, httptest.NewRequest("DELETE", "/silence?target="+target, nil))
+	if rec.Code != http.StatusOK || alertSilences.active(target) {
+		t.Errorf("DELETE: status %d, silenced %v", rec.Code, alertSilences.active(target))
+	}
+
+	for _, duration := range []string{"", "-1h", "1000h"} {
+		rec = httptest.NewRecorder()
+		silenceHandler(rec, httptest.NewRequest("POST", "/silence?target="+target+"&duration="+duration, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("POST with duration %q: status %d, want %d", duration, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestProbeHandlerSilencedDown(t *testing.T) {
+	defer func() { alertSilences = newSilences() }()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Close()
+	alertSilences.add(ts.URL, time.Hour)
+
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?target="+url.QueryEscape(ts.URL), nil))
+	for _, want := range []string{"probe_success 0", "probe_alerts_silenced 1"} {
+		if body := rec.Body.String(); !strings.Contains(body, want) {
+			t.Errorf("%s not found in:\n%s", want, body)
+		}
+	}
+}
@@ -5,11 +5,23 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
+)
+
+// Attachment colors
+const (
+	ColorGood    = "good"
+	ColorWarning = "warning"
+	ColorDanger  = "danger"
 )
 
 type SlackClient struct {
 	WebhookURL string
 	Payload    Payload
+
+	// TTFB thresholds for ColorForTTFB. Zero disables the band.
+	TTFBWarn time.Duration
+	TTFBCrit time.Duration
 }
 
 type Payload struct {
@@ -47,8 +59,10 @@ func (s *SlackClient) Post(title, pretext, text, color string) error {
 		Text:    text,
 		Color:   color,
 	}}
-	s.Payload.Attachments = attachments
-	body, err := json.Marshal(s.Payload)
+	// Copy so that concurrent posts don't share attachments
+	payload := s.Payload
+	payload.Attachments = attachments
+	body, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
@@ -63,3 +77,14 @@ func (s *SlackClient) Post(title, pretext, text, color string) error {
 
 	return nil
 }
+
+// ColorForTTFB returns the attachment color for the TTFB band d falls in.
+func (s *SlackClient) ColorForTTFB(d time.Duration) string {
+	switch {
+	case s.TTFBCrit > 0 && d >= s.TTFBCrit:
+		return ColorDanger
+	case s.TTFBWarn > 0 && d >= s.TTFBWarn:
+		return ColorWarning
+	}
+	return ColorGood
+}
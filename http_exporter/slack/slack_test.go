@@ -2,14 +2,14 @@ package slack
 
 import (
 	"io/ioutil"
-	"log"
 	"testing"
+	"time"
 )
 
 func TestPost(t *testing.T) {
 	data, err := ioutil.ReadFile(`./webhookurl.txt`)
 	if err != nil {
-		log.Println("Cannot open file")
+		t.Skip("Cannot open file: webhookurl.txt is required to post to Slack")
 	}
 	webhookurl := string(data)
 
@@ -22,3 +22,21 @@ func TestPost(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestColorForTTFB(t *testing.T) {
+	sc := &SlackClient{TTFBWarn: 200 * time.Millisecond, TTFBCrit: time.Second}
+	tests := []struct {
+		ttfb time.Duration
+		want string
+	}{
+		{100 * time.Millisecond, ColorGood},
+		{200 * time.Millisecond, ColorWarning},
+		{999 * time.Millisecond, ColorWarning},
+		{2 * time.Second, ColorDanger},
+	}
+	for _, tt := range tests {
+		if got := sc.ColorForTTFB(tt.ttfb); got != tt.want {
+			t.Errorf("ColorForTTFB(%s) = %q, want %q", tt.ttfb, got, tt.want)
+		}
+	}
+}
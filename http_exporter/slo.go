@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sloPhases are the phases a budget can be set for with -phase-slo.
+var sloPhases = map[string]func(*stats) time.Duration{
+	"dns":      (*stats).dnsLookup,
+	"tcp":      (*stats).tcpConnection,
+	"tls":      (*stats).tlsHandshake,
+	"server":   (*stats).serverProcessing,
+	"transfer": (*stats).contentTransfer,
+	"ttfb":     (*stats).ttfb,
+	"total":    (*stats).total,
+}
+
+// phaseSLOFlag is a comma separated list of phase budgets, e.g.
+// "dns=50ms,tls=200ms,server=500ms".
+type phaseSLOFlag map[string]time.Duration
+
+func (f *phaseSLOFlag) String() string {
+	var s []string
+	for _, phase := range f.phases() {
+		s = append(s, phase+"="+(*f)[phase].String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *phaseSLOFlag) Set(value string) error {
+	slos := phaseSLOFlag{}
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || sloPhases[kv[0]] == nil {
+			return fmt.Errorf("invalid phase budget %q", s)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid phase budget %q", s)
+		}
+		slos[kv[0]] = d
+	}
+	*f = slos
+	return nil
+}
+
+// phases returns the phases with a budget in a stable order.
+func (f *phaseSLOFlag) phases() []string {
+	var phases []string
+	for phase := range *f {
+		phases = append(phases, phase)
+	}
+	sort.Strings(phases)
+	return phases
+}
+
+// sendPhaseSLOs sends whether each phase of s was within its budget.
+func (c *httpStatsCollector) sendPhaseSLOs(ch chan<- prometheus.Metric, s stats) {
+	for _, phase := range c.phaseSLOs.phases() {
+		sendGauge(ch, c.phaseOK, bool2float(sloPhases[phase](&s) < c.phaseSLOs[phase]), phase)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPhaseSLOFlag(t *testing.T) {
+	var f phaseSLOFlag
+	if err := f.Set("server=500ms, dns=50ms"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.String(), "dns=50ms,server=500ms"; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+	for _, bad := range []string{"dns", "dns=fast", "dns=0s", "connect=1s"} {
+		if err := f.Set(bad); err == nil {
+			t.Errorf("Set(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestProbeHandlerPhaseSLO(t *testing.T) {
+	phaseSLOs = phaseSLOFlag{"server": 10 * time.Millisecond, "ttfb": time.Minute}
+	defer func() { phaseSLOs = phaseSLOFlag{} }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?target="+url.QueryEscape(ts.URL), nil))
+	for _, want := range []string{`probe_phase_ok{phase="server"} 0`, `probe_phase_ok{phase="ttfb"} 1`} {
+		if body := rec.Body.String(); !strings.Contains(body, want) {
+			t.Errorf("%s not found in:\n%s", want, body)
+		}
+	}
+	if body := rec.Body.String(); strings.Contains(body, `phase="dns"`) {
+		t.Errorf("phase without a budget reported:\n%s", body)
+	}
+}
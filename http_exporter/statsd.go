@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+)
+
+// statsdClient sends the results of scheduled probes to StatsD.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+}
+
+func newStatsdClient(addr, prefix string) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdClient{conn: conn, prefix: prefix}, nil
+}
+
+var statsdUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// send sends the phases of s as timers and success as a gauge, named
+// <prefix>.<target>.<metric> as plain StatsD has no labels. Only the phases
+// completed are sent for failures. A nil client sends nothing.
+func (c *statsdClient) send(target string, s stats, success bool) {
+	if c == nil {
+		return
+	}
+
+	name := c.prefix + "." + statsdUnsafe.ReplaceAllString(target, "_")
+	var b bytes.Buffer
+	for _, phase := range samplePhases {
+		d := phase.duration(&s)
+		// The phases a failed visit didn't reach are 0, see span
+		if !success && d == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s.%s:%g|ms\n", name, phase.name, ns2ms(d))
+	}
+	fmt.Fprintf(&b, "%s.probe_success:%g|g", name, bool2float(success))
+
+	// Lost packets are lost metrics, as usual with StatsD
+	c.conn.Write(b.Bytes())
+}
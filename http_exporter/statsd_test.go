@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestStatsdSend(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	c, err := newStatsdClient(pc.LocalAddr().String(), "httpmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	s := stats{Start: start, GotFirstResponseByte: start.Add(120 * time.Millisecond)}
+	c.send("https://www.example.com/", s, true)
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	for _, want := range []string{
+		"httpmon.https_www_example_com_.ttfb:120|ms\n",
+		"httpmon.https_www_example_com_.probe_success:1|g",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q not found in:\n%s", want, got)
+		}
+	}
+}
+
+func TestStatsdSendRefused(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	c := newHTTPStatsCollector("http://"+l.Addr().String()+"/", 10, defaultPhaseLabels, nil)
+	if c.statsd, err = newStatsdClient(pc.LocalAddr().String(), "httpmon"); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan prometheus.Metric, 1000)
+	c.Collect(ch)
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(buf[:n])
+	if !strings.HasSuffix(got, ".probe_success:0|g") {
+		t.Errorf("probe_success:0 not found in:\n%s", got)
+	}
+	// Only the refused connection attempt is timed
+	for _, line := range strings.Split(got, "\n") {
+		if strings.HasSuffix(line, "|ms") && !strings.Contains(line, ".tcp_handshake_time:") {
+			t.Errorf("unexpected timer %s", line)
+		}
+	}
+}
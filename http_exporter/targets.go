@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultScheduledTimeout is the timeout of scheduled probes(sec).
+const defaultScheduledTimeout = 10
+
+// schedulerTick is how often the scheduler checks for targets due to be probed.
+const schedulerTick = time.Second
+
+// targetOptions are the per target settings of a line of the targets file.
+type targetOptions struct {
+	interval time.Duration // overrides the scheduler interval if set
+	module   string
+}
+
+// targetScheduler probes the targets listed in a file on its own schedule
+// and serves the latest results, labeled by target, as a collector.
+type targetScheduler struct {
+	path      string
+	interval  time.Duration
+	keepAlive bool // probes of a target share a connection pool
+	prewarm   bool // connections are opened before the first probe
+	statsd    *statsdClient
+
+	mu         sync.Mutex
+	targets    []string
+	options    map[string]targetOptions
+	next       map[string]time.Time // when each target is probed next
+	results    map[string][]prometheus.Metric
+	transports map[string]*http.Transport // per target in keep-alive mode
+}
+
+func newTargetScheduler(path string, interval time.Duration) *targetScheduler {
+	return &targetScheduler{
+		path:       path,
+		interval:   interval,
+		options:    make(map[string]targetOptions),
+		next:       make(map[string]time.Time),
+		results:    make(map[string][]prometheus.Metric),
+		transports: make(map[string]*http.Transport),
+	}
+}
+
+// readTargets reads target URLs, one per line, each optionally followed by
+// interval=<duration> and module=<name>:
+//
+//	https://example.com/healthz interval=10s module=api_post
+//
+// Blank lines and lines starting with # are ignored, and malformed lines and
+// repeated targets are skipped.
+func readTargets(r io.Reader) ([]string, map[string]targetOptions, error) {
+	var targets []string
+	options := make(map[string]targetOptions)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		target := fields[0]
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "tcp") || u.Host == "" ||
+			(u.Scheme == "tcp" && u.Port() == "") {
+			log.Printf("Skipping malformed target on line %d: %q", n, line)
+			continue
+		}
+		if _, ok := options[target]; ok {
+			log.Printf("Skipping repeated target on line %d: %q", n, target)
+			continue
+		}
+		opts, err := parseTargetOptions(fields[1:])
+		if err != nil {
+			log.Printf("Skipping target on line %d: %s", n, err)
+			continue
+		}
+		targets = append(targets, target)
+		options[target] = opts
+	}
+	return targets, options, scanner.Err()
+}
+
+func parseTargetOptions(fields []string) (targetOptions, error) {
+	var opts targetOptions
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return opts, fmt.Errorf("invalid option %q", field)
+		}
+		switch kv[0] {
+		case "interval":
+			d, err := time.ParseDuration(kv[1])
+			if err != nil || d < schedulerTick {
+				return opts, fmt.Errorf("invalid interval %q, must be at least %s", kv[1], schedulerTick)
+			}
+			opts.interval = d
+		case "module":
+			if _, ok := modules[kv[1]]; !ok {
+				return opts, fmt.Errorf("unknown module %q", kv[1])
+			}
+			opts.module = kv[1]
+		default:
+			return opts, fmt.Errorf("unknown option %q", kv[0])
+		}
+	}
+	return opts, nil
+}
+
+// load (re)reads the targets file. Results of removed targets are dropped.
+func (t *targetScheduler) load() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	targets, options, err := readTargets(f)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.targets = targets
+	t.options = options
+	current := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		current[target] = true
+	}
+	for target := range t.results {
+		if !current[target] {
+			delete(t.results, target)
+		}
+	}
+	for target := range t.next {
+		if !current[target] {
+			delete(t.next, target)
+		}
+	}
+	for target, transport := range t.transports {
+		if !current[target] {
+			transport.CloseIdleConnections()
+			delete(t.transports, target)
+		}
+	}
+	log.Printf("Loaded %d targets from %s", len(targets), t.path)
+	return nil
+}
+
+// run probes each target on its interval, reloading the targets on SIGHUP.
+func (t *targetScheduler) run() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	if t.prewarm {
+		t.prewarmAll()
+	}
+	t.probeDue(time.Now())
+	for {
+		select {
+		case <-hup:
+			if err := t.load(); err != nil {
+				log.Printf("Targets file reload error: %s", err)
+			}
+		case now := <-ticker.C:
+			t.probeDue(now)
+		}
+	}
+}
+
+// probeDue starts probes of the targets due at now. Targets not probed yet,
+// e.g. added by a reload, are due immediately.
+func (t *targetScheduler) probeDue(now time.Time) {
+	t.mu.Lock()
+	var due []string
+	for _, target := range t.targets {
+		if next, ok := t.next[target]; ok && now.Before(next) {
+			continue
+		}
+		t.next[target] = now.Add(t.targetInterval(target))
+		due = append(due, target)
+	}
+	t.mu.Unlock()
+
+	for _, target := range due {
+		go t.probe(target)
+	}
+}
+
+// targetInterval returns the probe interval of target. t.mu must be held.
+func (t *targetScheduler) targetInterval(target string) time.Duration {
+	if d := t.options[target].interval; d > 0 {
+		return d
+	}
+	return t.interval
+}
+
+// prewarmAll requests each target once, leaving an idle connection to it
+// in its pool for the first probe.
+func (t *targetScheduler) prewarmAll() {
+	t.mu.Lock()
+	targets := t.targets
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			c := t.newCollector(target)
+			if c.tcp {
+				return // There is no connection to keep
+			}
+			_, resp, err := c.visit()
+			if err != nil {
+				log.Printf("Prewarming %s failed: %s", target, err)
+				return
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}(target)
+	}
+	wg.Wait()
+	log.Printf("Prewarmed connections to %d targets", len(targets))
+}
+
+// newCollector returns a collector for a scheduled probe of target.
+func (t *targetScheduler) newCollector(target string) *httpStatsCollector {
+	c := newProbeCollector(target, defaultScheduledTimeout, prometheus.Labels{"target": target})
+	c.statsd = t.statsd
+	t.mu.Lock()
+	module := t.options[target].module
+	t.mu.Unlock()
+	if m, ok := modules[module]; ok {
+		m.apply(c)
+	}
+	if t.keepAlive {
+		t.mu.Lock()
+		transport, ok := t.transports[target]
+		if !ok {
+			transport = c.newTransport()
+			t.transports[target] = transport
+		}
+		t.mu.Unlock()
+		c.transport = transport
+	}
+	return c
+}
+
+func (t *targetScheduler) probe(target string) {
+	c := t.newCollector(target)
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// The target may have been removed while it was probed
+	for _, current := range t.targets {
+		if current == target {
+			t.results[target] = metrics
+			return
+		}
+	}
+}
+
+// Describe sends nothing, so that the scheduler is an unchecked collector;
+// the metrics vary with the probe results.
+func (t *targetScheduler) Describe(ch chan<- *prometheus.Desc) {}
+
+func (t *targetScheduler) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, metrics := range t.results {
+		for _, m := range metrics {
+			ch <- m
+		}
+	}
+}
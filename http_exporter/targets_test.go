@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadTargets(t *testing.T) {
+	input := `# comment
+https://example.com
+
+http://example.org/healthz interval=5s
+not a url
+ftp://example.net
+tcp://db.example.net:5432
+tcp://db.example.net
+https://example.com interval=1m
+http://example.net interval=1ms
+http://example.net module=missing
+http://example.net timeout=1
+`
+	got, options, err := readTargets(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"https://example.com", "http://example.org/healthz", "tcp://db.example.net:5432"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readTargets = %q, want %q", got, want)
+	}
+	if d := options["http://example.org/healthz"].interval; d != 5*time.Second {
+		t.Errorf("interval = %s, want 5s", d)
+	}
+	if d := options["https://example.com"].interval; d != 0 {
+		t.Errorf("interval of the repeated target = %s, want 0", d)
+	}
+}
+
+func TestTargetSchedulerProbeDue(t *testing.T) {
+	ts := newTargetScheduler("", time.Minute)
+	ts.targets = []string{"http://a.invalid", "http://b.invalid"}
+	ts.options["http://b.invalid"] = targetOptions{interval: 10 * time.Second}
+
+	now := time.Now()
+	ts.probeDue(now)
+	ts.probeDue(now.Add(5 * time.Second))
+	for target, want := range map[string]time.Time{
+		"http://a.invalid": now.Add(time.Minute),
+		"http://b.invalid": now.Add(10 * time.Second),
+	} {
+		ts.mu.Lock()
+		next := ts.next[target]
+		ts.mu.Unlock()
+		if !next.Equal(want) {
+			t.Errorf("next probe of %s = %s, want %s", target, next, want)
+		}
+	}
+	ts.probeDue(now.Add(10 * time.Second))
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if next := ts.next["http://b.invalid"]; !next.Equal(now.Add(20 * time.Second)) {
+		t.Errorf("next probe of http://b.invalid = %s, want %s", next, now.Add(20*time.Second))
+	}
+}
+
+func TestTargetSchedulerPrewarm(t *testing.T) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	ts := newTargetScheduler("", time.Hour)
+	ts.keepAlive = true
+	ts.targets = []string{srv.URL}
+	ts.prewarmAll()
+	ts.probe(srv.URL)
+	ts.probe(srv.URL)
+
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("connections = %d, want 1", n)
+	}
+	if len(ts.results[srv.URL]) == 0 {
+		t.Error("no results for the target")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// visitTCP opens a TCP connection to the target, a tcp://host:port URL, and
+// performs the TLS handshake if tcpTLS is set, without speaking any protocol
+// over it. It suits databases, SMTP and other services that aren't HTTP.
+func (c *httpStatsCollector) visitTCP() (stats, error) {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return stats{}, err
+	}
+	if c.tcpTLS && c.noTLS {
+		return stats{}, errTLSDisabled
+	}
+	return c.dialConn(u.Host, u.Hostname(), nil, c.tcpTLS)
+}
+
+// collectTCP collects the metrics of a probe of a tcp target.
+func (c *httpStatsCollector) collectTCP(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	s, err := c.visitTCP()
+	sendGauge(ch, c.probeDuration, time.Since(start).Seconds())
+	if !s.Start.IsZero() {
+		sendGauge(ch, c.probeTimestamp, float64(s.Start.UnixNano())/1e9)
+	}
+	sendGauge(ch, c.connectSuccess, bool2float(!s.GotConn.IsZero()))
+	if err != nil {
+		log.Printf("TCP probe error: %s", err)
+		c.sendResult(ch, failureReason(err))
+		return
+	}
+	c.sendResult(ch, "")
+
+	// There is no HTTP response to take a status code from
+	labelValues := c.phaseLabelValues(0, "success")
+	sendGauge(ch, c.dnsLookup, ns2ms(s.dnsLookup()), labelValues...)
+	sendGauge(ch, c.tcpConnection, ns2ms(s.tcpConnection()), labelValues...)
+	if s.ipProtocol != 0 {
+		sendGauge(ch, c.ipProtocol, float64(s.ipProtocol))
+	}
+	if c.tcpTLS {
+		c.sendTLSHandshake(ch, s, labelValues)
+		c.collectTLS(ch, s)
+	}
+}
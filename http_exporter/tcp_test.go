@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProbeHandlerTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	// Only for its TLS listener
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	for _, tt := range []struct {
+		params  url.Values
+		want    []string
+		notWant string
+	}{
+		{
+			url.Values{"target": {"tcp://" + ln.Addr().String()}},
+			[]string{"probe_success 1", "probe_connect_success 1", "tcp_handshake_time{", "probe_ip_protocol 4"},
+			"tls_handshake_time{",
+		},
+		{
+			url.Values{"target": {"tcp://" + ts.Listener.Addr().String()}, "tcp_tls": {"true"}, "insecure_skip_verify": {"true"}},
+			[]string{"probe_success 1", "tls_handshake_time{", "probe_tls_version_info{"},
+			"probe_http_status_code",
+		},
+		{
+			url.Values{"target": {"tcp://" + closedAddr}},
+			[]string{"probe_success 0", "probe_connect_success 0"},
+			"tcp_handshake_time{",
+		},
+	} {
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+tt.params.Encode(), nil))
+		body := rec.Body.String()
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: %q not found in:\n%s", tt.params.Encode(), want, body)
+			}
+		}
+		if strings.Contains(body, tt.notWant) {
+			t.Errorf("%s: %q found in:\n%s", tt.params.Encode(), tt.notWant, body)
+		}
+	}
+
+	for _, bad := range []url.Values{
+		{"target": {"tcp://127.0.0.1"}},
+		{"target": {ts.URL}, "tcp_tls": {"true"}},
+		{"target": {"tcp://" + ln.Addr().String()}, "grpc": {"true"}},
+	} {
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+bad.Encode(), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", bad.Encode(), rec.Code)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// phaseTimeouts are the timeouts of the timeouts param, e.g.
+// "connect:2s,tls:3s,total:10s". Zero means not set.
+type phaseTimeouts struct {
+	dns     time.Duration // name resolution, like dns_timeout
+	connect time.Duration // name resolution and the TCP handshake
+	tls     time.Duration // the TLS handshake
+	total   time.Duration // the whole request, instead of timeout
+}
+
+// parseTimeouts parses a comma separated list of phase:duration.
+func parseTimeouts(s string) (phaseTimeouts, error) {
+	var t phaseTimeouts
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		kv := strings.SplitN(f, ":", 2)
+		if len(kv) != 2 {
+			return t, fmt.Errorf("invalid timeout %q, want phase:duration", f)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil || d <= 0 {
+			return t, fmt.Errorf("invalid duration %q", kv[1])
+		}
+		var p *time.Duration
+		switch kv[0] {
+		case "dns":
+			p = &t.dns
+		case "connect":
+			p = &t.connect
+		case "tls":
+			p = &t.tls
+		case "total":
+			p = &t.total
+		default:
+			return t, fmt.Errorf("unknown phase %q, want dns, connect, tls or total", kv[0])
+		}
+		if *p != 0 {
+			return t, fmt.Errorf("duplicate phase %q", kv[0])
+		}
+		*p = d
+	}
+	return t, nil
+}
+
+// requestTimeout returns the timeout of a whole request.
+func (c *httpStatsCollector) requestTimeout() time.Duration {
+	if c.totalTimeout > 0 {
+		return c.totalTimeout
+	}
+	return time.Duration(c.timeout) * time.Second
+}
+
+// tlsHandshakeContext returns ctx bounded by tlsTimeout, if set, for TLS
+// handshakes made outside of http.Transport.
+func (c *httpStatsCollector) tlsHandshakeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.tlsTimeout > 0 {
+		return context.WithTimeout(ctx, c.tlsTimeout)
+	}
+	return context.WithCancel(ctx)
+}
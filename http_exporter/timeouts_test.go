@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeouts(t *testing.T) {
+	got, err := parseTimeouts("connect:2s, tls:3s,total:10s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (phaseTimeouts{connect: 2 * time.Second, tls: 3 * time.Second, total: 10 * time.Second}); got != want {
+		t.Errorf("parseTimeouts = %+v, want %+v", got, want)
+	}
+	for _, bad := range []string{"connect", "connect:fast", "connect:0s", "server:1s", "tls:1s,tls:2s"} {
+		if _, err := parseTimeouts(bad); err == nil {
+			t.Errorf("parseTimeouts(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestProbeHandlerTimeouts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer ts.Close()
+
+	// Accepts connections, but never completes a TLS handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	for _, tt := range []struct {
+		target   string
+		timeouts string
+	}{
+		{ts.URL, "total:100ms"},
+		{"https://" + ln.Addr().String(), "tls:100ms"},
+	} {
+		q := url.Values{"target": {tt.target}, "timeouts": {tt.timeouts}, "timeout": {"5"}}
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		if d := time.Since(start); d > 900*time.Millisecond {
+			t.Errorf("%s: probe took %s", tt.timeouts, d)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, "probe_success 0") {
+			t.Errorf("%s: probe_success 0 not found in:\n%s", tt.timeouts, body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?target="+url.QueryEscape(ts.URL)+"&timeouts=read:1s", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status with an unknown phase = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestProbeHandlerModuleTimeouts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer ts.Close()
+
+	var err error
+	if modules, err = loadModules(writeConfig(t, "modules:\n  fast:\n    timeout: 100ms\n")); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { modules = nil }()
+
+	// timeouts without total keeps the timeout of the module
+	q := url.Values{"target": {ts.URL}, "module": {"fast"}, "timeouts": {"connect:2s"}}
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+	if d := time.Since(start); d > 900*time.Millisecond {
+		t.Errorf("probe took %s, want the module's timeout of 100ms", d)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "probe_success 0") {
+		t.Errorf("probe_success 0 not found in:\n%s", body)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// visitTLS dials the target and performs the TLS handshake only, without
+// sending an HTTP request.
+func (c *httpStatsCollector) visitTLS() (stats, error) {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return stats{}, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+	return c.dialConn(addr, u.Hostname(), []string{"h2", "http/1.1"}, true)
+}
+
+// dialConn dials addr, and performs the TLS handshake with serverName and
+// nextProtos if handshake is set. It closes the connection right away.
+func (c *httpStatsCollector) dialConn(addr, serverName string, nextProtos []string, handshake bool) (stats, error) {
+	var s stats
+	trace := newClientTrace(&s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout())
+	defer cancel()
+	ctx = httptrace.WithClientTrace(ctx, trace)
+
+	dial := c.dialer()
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	s.Start = time.Now()
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return s, err
+	}
+	defer conn.Close()
+	s.GotConn = time.Now()
+	if !handshake {
+		return s, nil
+	}
+
+	cfg := c.tlsClientConfig(serverName)
+	cfg.NextProtos = nextProtos
+	tlsConn := tls.Client(conn, cfg)
+	hsCtx, hsCancel := c.tlsHandshakeContext(ctx)
+	defer hsCancel()
+	trace.TLSHandshakeStart()
+	err = tlsConn.HandshakeContext(hsCtx)
+	trace.TLSHandshakeDone(tlsConn.ConnectionState(), err)
+	return s, err
+}
+
+// collectTLSOnly collects the metrics of a tls_only probe.
+func (c *httpStatsCollector) collectTLSOnly(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	s, err := c.visitTLS()
+	sendGauge(ch, c.probeDuration, time.Since(start).Seconds())
+	sendGauge(ch, c.probeTimestamp, float64(s.Start.UnixNano())/1e9)
+	sendGauge(ch, c.connectSuccess, bool2float(!s.GotConn.IsZero()))
+	if err != nil {
+		log.Printf("TLS handshake error: %s", err)
+		c.sendResult(ch, failureReason(err))
+		return
+	}
+	c.sendResult(ch, "")
+
+	// There is no HTTP response to take a status code from
+	c.sendTLSHandshake(ch, s, c.phaseLabelValues(0, "success"))
+	c.collectTLS(ch, s)
+}
+
+// collectTLS collects the metrics describing the TLS connection, if any.
+func (c *httpStatsCollector) collectTLS(ch chan<- prometheus.Metric, s stats) {
+	if s.tlsCert == nil {
+		return
+	}
+
+	// There is no metric of TLS 1.3 early data: crypto/tls never sends it
+	// as a client, nor does tls.ConnectionState report it, so it would
+	// always be 0. Resumption, which 0-RTT builds on, is reported.
+	sendGauge(ch, c.tlsResumed, bool2float(s.tlsResumed))
+
+	alpn := s.alpn
+	if alpn == "" {
+		alpn = "none"
+	}
+	sendGauge(ch, c.alpnInfo, 1, alpn)
+	sendGauge(ch, c.tlsVersionInfo, 1, tls.VersionName(s.tlsVersion))
+	sendGauge(ch, c.tlsCipherInfo, 1, tls.CipherSuiteName(s.tlsCipherSuite))
+	cert := s.tlsCert
+	keyType, keySize := certKey(cert)
+	sendGauge(ch, c.tlsCertInfo, 1, cert.Subject.String(), cert.Issuer.String(), cert.SerialNumber.Text(16),
+		certSignatureAlgorithm(cert), keyType, keySize)
+	sendGauge(ch, c.tlsEarliestExpiry, float64(s.tlsExpiry.Unix()))
+	sendGauge(ch, c.tlsExpiryDays, s.tlsExpiry.Sub(s.Start).Hours()/24)
+	if fraction, ok := certLifetimeFraction(s.tlsCert, s.Start); ok {
+		sendGauge(ch, c.certLifetimeFraction, fraction)
+	}
+}
+
+// earliestExpiry returns the earliest NotAfter of certs.
+func earliestExpiry(certs []*x509.Certificate) time.Time {
+	var earliest time.Time
+	for _, cert := range certs {
+		if earliest.IsZero() || cert.NotAfter.Before(earliest) {
+			earliest = cert.NotAfter
+		}
+	}
+	return earliest
+}
+
+// certLifetimeFraction returns the fraction of the validity period of cert
+// remaining at now. ok is false if the validity period is empty.
+func certLifetimeFraction(cert *x509.Certificate, now time.Time) (fraction float64, ok bool) {
+	total := cert.NotAfter.Sub(cert.NotBefore)
+	if total <= 0 {
+		return 0, false
+	}
+	return float64(cert.NotAfter.Sub(now)) / float64(total), true
+}
+
+// certSignatureAlgorithm returns the name of the signature algorithm of
+// cert, or "unknown" so that odd certificates can't blow up cardinality.
+func certSignatureAlgorithm(cert *x509.Certificate) string {
+	if cert.SignatureAlgorithm == x509.UnknownSignatureAlgorithm {
+		return "unknown"
+	}
+	return cert.SignatureAlgorithm.String()
+}
+
+// certKey returns the type and size in bits of the public key of cert.
+func certKey(cert *x509.Certificate) (keyType, keySize string) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", strconv.Itoa(key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA", strconv.Itoa(key.Curve.Params().BitSize)
+	case ed25519.PublicKey:
+		return "Ed25519", "256"
+	}
+	return "unknown", "unknown"
+}
+
+// isTLSError reports whether err comes from the TLS handshake.
+func isTLSError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	return errors.As(err, &verifyErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCertLifetimeFraction(t *testing.T) {
+	notBefore := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(90 * 24 * time.Hour)}
+
+	tests := []struct {
+		now  time.Time
+		want float64
+	}{
+		{notBefore, 1},
+		{notBefore.Add(63 * 24 * time.Hour), 0.3},
+		{notBefore.Add(90 * 24 * time.Hour), 0},
+	}
+	for _, tt := range tests {
+		got, ok := certLifetimeFraction(cert, tt.now)
+		if !ok || got < tt.want-1e-9 || got > tt.want+1e-9 {
+			t.Errorf("certLifetimeFraction at %s = %v, %v, want %v, true", tt.now, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := certLifetimeFraction(&x509.Certificate{NotBefore: notBefore, NotAfter: notBefore}, notBefore); ok {
+		t.Error("certLifetimeFraction of an empty validity period is ok, want not ok")
+	}
+}
+
+func TestCertKey(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	cert := ts.Certificate()
+	if keyType, keySize := certKey(cert); keyType != "RSA" || keySize != "2048" {
+		t.Errorf("certKey = %s, %s, want RSA, 2048", keyType, keySize)
+	}
+	if got := certSignatureAlgorithm(cert); got != "SHA256-RSA" {
+		t.Errorf("certSignatureAlgorithm = %s, want SHA256-RSA", got)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyType, keySize := certKey(&x509.Certificate{PublicKey: &key.PublicKey}); keyType != "ECDSA" || keySize != "256" {
+		t.Errorf("certKey of P-256 = %s, %s, want ECDSA, 256", keyType, keySize)
+	}
+	if got := certSignatureAlgorithm(&x509.Certificate{}); got != "unknown" {
+		t.Errorf("certSignatureAlgorithm of no algorithm = %s, want unknown", got)
+	}
+}
+
+func TestProbeTLSHandshakeResumed(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	transport := ts.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	transport.DisableKeepAlives = true
+
+	for _, want := range []string{"false", "true"} {
+		c := newHTTPStatsCollector(ts.URL, 10, defaultPhaseLabels, nil)
+		c.transport = transport
+		ch := make(chan prometheus.Metric, 100)
+		c.Collect(ch)
+		close(ch)
+
+		found := false
+		for m := range ch {
+			if strings.Contains(m.Desc().String(), `"tls_handshake_time"`) {
+				var pb dto.Metric
+				m.Write(&pb)
+				for _, l := range pb.Label {
+					found = found || (l.GetName() == "resumed" && l.GetValue() == want)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("tls_handshake_time{resumed=%q} not collected", want)
+		}
+	}
+}
+
+func TestEarliestExpiry(t *testing.T) {
+	leaf := &x509.Certificate{NotAfter: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	intermediate := &x509.Certificate{NotAfter: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if got := earliestExpiry([]*x509.Certificate{leaf, intermediate}); !got.Equal(intermediate.NotAfter) {
+		t.Errorf("earliestExpiry = %s, want %s", got, intermediate.NotAfter)
+	}
+}
+
+func TestProbeCertExpiry(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	c := newHTTPStatsCollector(ts.URL, 10, defaultPhaseLabels, nil)
+	c.transport = ts.Client().Transport.(*http.Transport)
+	ch := make(chan prometheus.Metric, 100)
+	c.Collect(ch)
+	close(ch)
+
+	want := float64(ts.Certificate().NotAfter.Unix())
+	found := false
+	for m := range ch {
+		if strings.Contains(m.Desc().String(), `"probe_ssl_earliest_cert_expiry"`) {
+			var pb dto.Metric
+			m.Write(&pb)
+			if got := pb.GetGauge().GetValue(); got != want {
+				t.Errorf("probe_ssl_earliest_cert_expiry = %v, want %v", got, want)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Error("probe_ssl_earliest_cert_expiry not sent")
+	}
+}
+
+func TestVisitHTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	for _, http11 := range []bool{false, true} {
+		c := newHTTPStatsCollector(ts.URL, 10, defaultPhaseLabels, nil)
+		c.insecureSkipVerify = true
+		c.http11 = http11
+		_, resp, err := c.visit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if want := map[bool]int{false: 2, true: 1}[http11]; resp.ProtoMajor != want {
+			t.Errorf("http11 %v: got %s, want HTTP/%d", http11, resp.Proto, want)
+		}
+	}
+}
+
+// writeKeyPair writes a self-signed certificate and its key as PEM files.
+func writeKeyPair(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "probe"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "keypair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestProbeHandlerClientCert(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	certFile, keyFile := writeKeyPair(t)
+	var err error
+	modules, err = loadModules(writeConfig(t, fmt.Sprintf(`
+modules:
+  mtls:
+    tls_config:
+      insecure_skip_verify: true
+      cert_file: %s
+      key_file: %s
+  no_cert:
+    tls_config:
+      insecure_skip_verify: true
+`, certFile, keyFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { modules = nil }()
+
+	for module, want := range map[string]string{"mtls": "probe_success 1", "no_cert": "probe_success 0"} {
+		q := url.Values{"target": {ts.URL}, "module": {module}}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		if body := rec.Body.String(); !strings.Contains(body, want) {
+			t.Errorf("module %s: %q not found in:\n%s", module, want, body)
+		}
+	}
+
+	if _, err := loadModules(writeConfig(t, "modules:\n  m:\n    tls_config:\n      cert_file: "+certFile+"\n")); err == nil {
+		t.Error("loadModules with cert_file but no key_file succeeded, want error")
+	}
+}
+
+func TestProbeHandlerCAFile(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0600)
+
+	modules, err = loadModules(writeConfig(t, fmt.Sprintf(`
+modules:
+  ca_file:
+    tls_config:
+      ca_file: %s
+  ca_dir:
+    tls_config:
+      ca_dir: %s
+`, caFile, dir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { modules = nil }()
+
+	tests := []struct {
+		params url.Values
+		want   string
+	}{
+		{url.Values{"module": {"ca_file"}}, "probe_success 1"},
+		{url.Values{"module": {"ca_dir"}}, "probe_success 1"},
+		{url.Values{}, "probe_success 0"},
+		{url.Values{"insecure_skip_verify": {"true"}}, "probe_success 1"},
+	}
+	for _, tt := range tests {
+		q := tt.params
+		q.Set("target", ts.URL)
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		if body := rec.Body.String(); !strings.Contains(body, tt.want) {
+			t.Errorf("%s: %q not found in:\n%s", q.Encode(), tt.want, body)
+		}
+	}
+
+	if _, err := loadModules(writeConfig(t, "modules:\n  m:\n    tls_config:\n      ca_file: "+filepath.Join(dir, "README")+"\n")); err == nil {
+		t.Error("loadModules with a ca_file without certificates succeeded, want error")
+	}
+}
+
+func TestProbeHandlerTLSOnly(t *testing.T) {
+	var requests int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	defer ts.Close()
+
+	q := url.Values{"target": {ts.URL}, "tls_only": {"true"}, "insecure_skip_verify": {"true"}}
+	rec := httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+	body := rec.Body.String()
+	for _, want := range []string{"probe_success 1", "probe_connect_success 1", "tls_handshake_time{", "probe_tls_version_info{", "probe_ssl_earliest_cert_expiry "} {
+		if !strings.Contains(body, want) {
+			t.Errorf("%q not found in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "probe_http_status_code") {
+		t.Errorf("probe_http_status_code sent for tls_only:\n%s", body)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("HTTP requests = %d, want 0", n)
+	}
+
+	q = url.Values{"target": {strings.Replace(ts.URL, "https:", "http:", 1)}, "tls_only": {"true"}}
+	rec = httptest.NewRecorder()
+	prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("tls_only with an http target: status = %d, want 400", rec.Code)
+	}
+}
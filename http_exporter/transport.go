@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// sharedTLSSessionCache lets probes resume TLS sessions established by
+// earlier probes.
+var sharedTLSSessionCache = tls.NewLRUClientSessionCache(0)
+
+func (c *httpStatsCollector) newTransport() *http.Transport {
+	t := &http.Transport{
+		Proxy:                  http.ProxyFromEnvironment,
+		MaxResponseHeaderBytes: c.maxResponseHeaderBytes,
+		TLSHandshakeTimeout:    c.tlsTimeout,
+	}
+	if c.tlsSessionCache != nil || c.insecureSkipVerify || c.serverName != "" || c.clientCert != nil || c.rootCAs != nil {
+		t.TLSClientConfig = c.tlsClientConfig("")
+	}
+	if c.http11 {
+		// A non-nil empty map disables HTTP/2
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	} else {
+		// Negotiated over TLS via ALPN. Without this, the custom dialers and
+		// TLS config below would silently disable HTTP/2.
+		t.ForceAttemptHTTP2 = true
+	}
+	if c.socks5 != nil || c.proxyProtocol != nil {
+		t.Proxy = nil
+	}
+	t.DialContext = c.dialer()
+	if c.http10 {
+		t.DisableKeepAlives = true
+		dial := t.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &http10Conn{Conn: conn}, nil
+		}
+	}
+	return t
+}
+
+// tlsClientConfig returns the TLS config of probe connections to
+// serverName, which the serverName of the collector overrides if set.
+func (c *httpStatsCollector) tlsClientConfig(serverName string) *tls.Config {
+	if c.serverName != "" {
+		serverName = c.serverName
+	}
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		ClientSessionCache: c.tlsSessionCache,
+		InsecureSkipVerify: c.insecureSkipVerify,
+		RootCAs:            c.rootCAs,
+	}
+	if c.clientCert != nil {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.clientCert.load()
+		}
+	}
+	return cfg
+}
+
+// clientCert is a client certificate for mutual TLS. It is read on every
+// handshake that asks for it, as it may be rotated like tokens.
+type clientCert struct {
+	certFile string
+	keyFile  string
+}
+
+func (cc *clientCert) load() (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(cc.certFile, cc.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// loadCAs returns a pool of the PEM certificates in file and in the files of
+// dir, either of which may be empty.
+func loadCAs(file, dir string) (*x509.CertPool, error) {
+	var files []string
+	if file != "" {
+		files = append(files, file)
+	}
+	if dir != "" {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Mode().IsRegular() {
+				files = append(files, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+
+	pool := x509.NewCertPool()
+	found := false
+	for _, f := range files {
+		pem, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		// Other files in dir are skipped, but file must have some
+		ok := pool.AppendCertsFromPEM(pem)
+		if !ok && f == file {
+			return nil, fmt.Errorf("no PEM certificates in %s", f)
+		}
+		found = found || ok
+	}
+	if !found {
+		return nil, errors.New("no CA certificates found")
+	}
+	return pool, nil
+}
+
+// http10Conn rewrites the request line of the first request written to it
+// to HTTP/1.0, as net/http always writes HTTP/1.1. Keep-alive must be
+// disabled so that there is only one request per connection. The request
+// line always comes in the first write, and plain HTTP only, as TLS would
+// encrypt it below this connection.
+type http10Conn struct {
+	net.Conn
+	rewritten bool
+}
+
+func (c *http10Conn) Write(p []byte) (int, error) {
+	if c.rewritten {
+		return c.Conn.Write(p)
+	}
+	c.rewritten = true
+	end := bytes.Index(p, []byte("\r\n"))
+	if end < 0 || !bytes.HasSuffix(p[:end], []byte(" HTTP/1.1")) {
+		return c.Conn.Write(p)
+	}
+	q := append([]byte(nil), p...)
+	q[end-1] = '0'
+	return c.Conn.Write(q)
+}
+
+// dialer returns the dial function for probes, or nil for net/http's default.
+// All probe types dial with it, so that e.g. proxy_protocol applies to them.
+func (c *httpStatsCollector) dialer() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	switch {
+	case c.socks5 != nil:
+		// The SOCKS5 server resolves the target, so dnsTimeout doesn't apply.
+		dial = c.socks5DialContext
+	case c.dnsTimeout > 0 || c.connectTimeout > 0 || c.dnsServer != nil || c.sourceIP != nil || c.resolve != nil || c.nagle || c.ipNetwork != "" || c.preferredIP != "":
+		dial = c.dialContext
+	}
+	if c.proxyProtocol != nil {
+		if dial == nil {
+			dial = (&net.Dialer{}).DialContext
+		}
+		dial = c.proxyProtocol.wrapDial(dial)
+	}
+	return dial
+}
+
+// parseSOCKS5 parses a socks5 param, either host:port or
+// socks5://[user:password@]host:port.
+func parseSOCKS5(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "socks5://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Port() == "" {
+		return nil, errors.New("missing port")
+	}
+	return u, nil
+}
+
+func (c *httpStatsCollector) socks5DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if c.socks5.User != nil {
+		password, _ := c.socks5.User.Password()
+		auth = &proxy.Auth{
+			User:     c.socks5.User.Username(),
+			Password: password,
+		}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", c.socks5.Host, auth, c.netDialer())
+	if err != nil {
+		return nil, err
+	}
+	return dialer.(proxy.ContextDialer).DialContext(ctx, network, c.resolve.rewrite(addr))
+}
+
+func (c *httpStatsCollector) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c.ipNetwork != "" {
+		network = c.ipNetwork
+	}
+	addr = c.resolve.rewrite(addr)
+	if c.preferredIP != "" {
+		var err error
+		if addr, err = c.resolvePreferred(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := c.netDialer().DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	// Go sets TCP_NODELAY on every TCP connection after connecting, so
+	// clearing it in net.Dialer.Control would be undone; clear it here.
+	if tcpConn, ok := conn.(*net.TCPConn); ok && c.nagle {
+		if err := tcpConn.SetNoDelay(false); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// resolveOverride pins host:port to an IP, like curl's --resolve. The
+// Host header and SNI still come from the URL.
+type resolveOverride struct {
+	hostPort string
+	ip       net.IP
+}
+
+// parseResolve parses a resolve param of the form host:port:ip.
+func parseResolve(s string) (*resolveOverride, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return nil, errors.New("must be host:port:ip")
+	}
+	if _, err := strconv.ParseUint(parts[1], 10, 16); err != nil {
+		return nil, fmt.Errorf("invalid port %q", parts[1])
+	}
+	ip := net.ParseIP(strings.Trim(parts[2], "[]"))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP %q", parts[2])
+	}
+	return &resolveOverride{
+		hostPort: net.JoinHostPort(parts[0], parts[1]),
+		ip:       ip,
+	}, nil
+}
+
+// rewrite returns the address to dial for addr. A nil override keeps addr.
+func (r *resolveOverride) rewrite(addr string) string {
+	if r == nil || !strings.EqualFold(addr, r.hostPort) {
+		return addr
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	return net.JoinHostPort(r.ip.String(), port)
+}
+
+// netDialer returns a dialer binding to sourceIP if set. Names are resolved
+// by dnsServer if set, and resolution is bounded by dnsTimeout independently
+// of the overall probe timeout.
+func (c *httpStatsCollector) netDialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: c.connectTimeout}
+	if c.sourceIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: c.sourceIP}
+	}
+	if c.dnsTimeout <= 0 && c.dnsServer == nil {
+		return dialer
+	}
+
+	var dnsDeadline time.Time
+	if c.dnsTimeout > 0 {
+		dnsDeadline = time.Now().Add(c.dnsTimeout)
+	}
+	dialer.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if c.dnsServer != nil {
+				address = c.dnsServer.addr
+				if c.dnsServer.network != "" {
+					network = c.dnsServer.network
+				}
+			}
+			if dnsDeadline.IsZero() {
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			}
+
+			ctx, cancel := context.WithDeadline(ctx, dnsDeadline)
+			defer cancel()
+
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			// Every query of this resolution shares the same deadline.
+			// The resolver sets its own deadlines on the connection, so
+			// they have to be capped.
+			if udpConn, ok := conn.(*net.UDPConn); ok {
+				// Still a PacketConn, which the resolver checks for
+				return &dnsDeadlinePacketConn{udpConn, dnsDeadline}, nil
+			}
+			return &dnsDeadlineConn{conn, dnsDeadline}, nil
+		},
+	}
+	return dialer
+}
+
+// dnsDeadlineConn caps the deadlines set on it at deadline.
+type dnsDeadlineConn struct {
+	net.Conn
+	deadline time.Time
+}
+
+func (c *dnsDeadlineConn) SetDeadline(t time.Time) error {
+	return c.Conn.SetDeadline(capDeadline(t, c.deadline))
+}
+
+func (c *dnsDeadlineConn) SetReadDeadline(t time.Time) error {
+	return c.Conn.SetReadDeadline(capDeadline(t, c.deadline))
+}
+
+func (c *dnsDeadlineConn) SetWriteDeadline(t time.Time) error {
+	return c.Conn.SetWriteDeadline(capDeadline(t, c.deadline))
+}
+
+// dnsDeadlinePacketConn is a dnsDeadlineConn for UDP.
+type dnsDeadlinePacketConn struct {
+	*net.UDPConn
+	deadline time.Time
+}
+
+func (c *dnsDeadlinePacketConn) SetDeadline(t time.Time) error {
+	return c.UDPConn.SetDeadline(capDeadline(t, c.deadline))
+}
+
+func (c *dnsDeadlinePacketConn) SetReadDeadline(t time.Time) error {
+	return c.UDPConn.SetReadDeadline(capDeadline(t, c.deadline))
+}
+
+func (c *dnsDeadlinePacketConn) SetWriteDeadline(t time.Time) error {
+	return c.UDPConn.SetWriteDeadline(capDeadline(t, c.deadline))
+}
+
+func capDeadline(t, deadline time.Time) time.Time {
+	if t.IsZero() || t.After(deadline) {
+		return deadline
+	}
+	return t
+}
+
+// dnsServer is a DNS server to resolve probe targets with.
+type dnsServer struct {
+	network string // udp or tcp, empty to use UDP with TCP fallback
+	addr    string // ip:port
+}
+
+// parseDNSServer parses a dns_server param, an IP with an optional port
+// (53 by default) and an optional udp:// or tcp:// prefix.
+func parseDNSServer(s string) (*dnsServer, error) {
+	var network string
+	if i := strings.Index(s, "://"); i >= 0 {
+		network, s = s[:i], s[i+len("://"):]
+		if network != "udp" && network != "tcp" {
+			return nil, fmt.Errorf("unsupported protocol %q", network)
+		}
+	}
+
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		// No port
+		host, port = strings.Trim(s, "[]"), "53"
+	}
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("%q is not an IP address", host)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return &dnsServer{network: network, addr: net.JoinHostPort(host, port)}, nil
+}
+
+// resolvePreferred resolves the host of addr to an address of the preferred
+// IP protocol, falling back to the other one if allowed. IPs are kept.
+func (c *httpStatsCollector) resolvePreferred(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return addr, err
+	}
+	resolver := c.netDialer().Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+	ip, err := preferIP(ips, c.preferredIP, c.ipFallback)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", host, err)
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// errNoPreferredIP is returned when a host has no address of the preferred
+// IP protocol and falling back isn't allowed.
+var errNoPreferredIP = errors.New("no address of the preferred IP protocol")
+
+// preferIP returns the first of ips of the preferred protocol, ip4 or ip6,
+// or the first of the others if fallback is set.
+func preferIP(ips []net.IP, preferred string, fallback bool) (net.IP, error) {
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (preferred == "ip4") {
+			return ip, nil
+		}
+	}
+	if fallback && len(ips) > 0 {
+		return ips[0], nil
+	}
+	return nil, errNoPreferredIP
+}
+
+// ipProtocol returns 4 or 6 for the IP of addr, host:port, or 0 for others.
+func ipProtocol(addr string) int {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return 0
+	case ip.To4() != nil:
+		return 4
+	}
+	return 6
+}
+
+// dnsRecordNetworks maps dns_record_type params to the dial network that
+// makes the resolver look up only that record type.
+var dnsRecordNetworks = map[string]string{
+	"A":    "tcp4",
+	"AAAA": "tcp6",
+}
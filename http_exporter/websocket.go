@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/websocket"
+)
+
+var (
+	errWSHandshake    = errors.New("WebSocket upgrade failed")
+	errWSNoEcho       = errors.New("no WebSocket echo before the timeout")
+	errWSEchoMismatch = errors.New("WebSocket echo differs from the message sent")
+)
+
+// visitWebSocket upgrades a connection to the target to a WebSocket, sends
+// a message and waits for the server to echo it, returning the round-trip
+// time of the message.
+func (c *httpStatsCollector) visitWebSocket() (time.Duration, error) {
+	u, err := url.Parse(c.url)
+	if err != nil {
+		return 0, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	deadline := time.Now().Add(c.requestTimeout())
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	dial := c.dialer()
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, c.tlsClientConfig(u.Hostname()))
+		hsCtx, hsCancel := c.tlsHandshakeContext(ctx)
+		defer hsCancel()
+		if err := tlsConn.HandshakeContext(hsCtx); err != nil {
+			return 0, err
+		}
+		conn = tlsConn
+	}
+
+	wsURL := *u
+	wsURL.Scheme = "ws"
+	if u.Scheme == "https" {
+		wsURL.Scheme = "wss"
+	}
+	origin := &url.URL{Scheme: u.Scheme, Host: u.Host}
+	config := &websocket.Config{
+		Location: &wsURL,
+		Origin:   origin,
+		Version:  websocket.ProtocolVersionHybi13,
+	}
+	if c.host != "" {
+		config.Location.Host = c.host
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", errWSHandshake, err)
+	}
+	// Sends a close frame before closing the connection
+	defer ws.Close()
+
+	msg := fmt.Sprintf("http_exporter %d", time.Now().UnixNano())
+	start := time.Now()
+	if err := websocket.Message.Send(ws, msg); err != nil {
+		return 0, err
+	}
+	var echo string
+	if err := websocket.Message.Receive(ws, &echo); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return 0, errWSNoEcho
+		}
+		return 0, err
+	}
+	rtt := time.Since(start)
+	if echo != msg {
+		return rtt, errWSEchoMismatch
+	}
+	return rtt, nil
+}
+
+// collectWebSocket collects the metrics of a websocket_echo probe.
+func (c *httpStatsCollector) collectWebSocket(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	rtt, err := c.visitWebSocket()
+	sendGauge(ch, c.probeDuration, time.Since(start).Seconds())
+	if err != nil {
+		log.Printf("WebSocket echo error: %s", err)
+		c.sendResult(ch, wsFailureReason(err))
+		return
+	}
+	sendGauge(ch, c.wsRoundtrip, ns2ms(rtt))
+	c.sendResult(ch, "")
+}
+
+// wsFailureReason returns the failure reason of a websocket_echo probe.
+func wsFailureReason(err error) string {
+	switch {
+	case errors.Is(err, errWSHandshake):
+		return "ws_handshake"
+	case errors.Is(err, errWSNoEcho):
+		return "ws_no_echo"
+	case errors.Is(err, errWSEchoMismatch):
+		return "ws_echo_mismatch"
+	}
+	return failureReason(err)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestProbeHandlerWebSocketEcho(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/echo", websocket.Handler(func(ws *websocket.Conn) {
+		io.Copy(ws, ws)
+	}))
+	mux.Handle("/silent", websocket.Handler(func(ws *websocket.Conn) {
+		io.Copy(ioutil.Discard, ws)
+	}))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/echo", []string{"probe_success 1", "ws_roundtrip_time "}},
+		{"/silent", []string{"probe_success 0", `reason="ws_no_echo"`}},
+		{"/missing", []string{"probe_success 0", `reason="ws_handshake"`}},
+	}
+	for _, tt := range tests {
+		q := url.Values{"target": {ts.URL + tt.path}, "websocket_echo": {"true"}, "timeout": {"1"}}
+		rec := httptest.NewRecorder()
+		prometheusReqsHandler(rec, httptest.NewRequest("GET", "/probe?"+q.Encode(), nil))
+		for _, want := range tt.want {
+			if body := rec.Body.String(); !strings.Contains(body, want) {
+				t.Errorf("%s: %s not found in:\n%s", tt.path, want, body)
+			}
+		}
+	}
+}